@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Albums are an AlbumMessage announcing how many images and videos follow,
+// and then every item with the key of that message as its parent, which is
+// how recipients group them. All items are uploaded before anything is sent.
+
+const (
+	albumMaxItems          = 30
+	albumUploadConcurrency = 4
+)
+
+type albumItem struct {
+	Image         string // data URL, either Image or Video
+	Video         string
+	Caption       string
+	JPEGThumbnail []byte // video
+}
+
+type albumResult struct {
+	Index     int
+	Id        string     `json:",omitempty"`
+	JobId     string     `json:",omitempty"` // queued or scheduled albums
+	Timestamp *time.Time `json:",omitempty"`
+	Error     string     `json:",omitempty"`
+}
+
+// Sends images and videos as an album
+func (s *server) SendAlbum() http.HandlerFunc {
+
+	type albumStruct struct {
+		Phone               string
+		Id                  string // id of the album message, generated when empty
+		Items               []albumItem
+		Ephemeral           bool
+		EphemeralExpiration uint32
+		sendOptions
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t albumStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
+			return
+		}
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Phone"))
+			return
+		}
+		if len(t.Items) < 2 || len(t.Items) > albumMaxItems {
+			s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("Albums must have 2 to %d Items", albumMaxItems)))
+			return
+		}
+
+		sendAt, err := scheduledAt(s.db, userid, t.sendOptions)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		typing := shouldSimulateTyping(s.db, userid, recipient, t.sendOptions)
+
+		var images, videos uint32
+		contents := make([]messageContent, len(t.Items))
+		for i, item := range t.Items {
+			switch {
+			case item.Image != "" && item.Video == "":
+				contents[i] = messageContent{Type: "image", Image: item.Image, Caption: item.Caption}
+				images++
+			case item.Video != "" && item.Image == "":
+				contents[i] = messageContent{Type: "video", Video: item.Video, Caption: item.Caption, JPEGThumbnail: item.JPEGThumbnail}
+				videos++
+			default:
+				s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("Item %d must have either Image or Video", i)))
+				return
+			}
+		}
+
+		// Upload everything first, so a failed upload sends nothing
+		msgs := make([]*waProto.Message, len(contents))
+		errs := make([]error, len(contents))
+		var wg sync.WaitGroup
+		slots := make(chan struct{}, albumUploadConcurrency)
+		for i := range contents {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+				msgs[i], errs[i] = buildMessage(r.Context(), clientPointer[userid], contents[i])
+				if errs[i] == nil {
+					msgs[i], errs[i] = s.applyEnvelope(r.Context(), userid, recipient, msgs[i], &sendRequest{
+						messageContent:      contents[i],
+						Ephemeral:           t.Ephemeral,
+						EphemeralExpiration: t.EphemeralExpiration,
+					})
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		var failed []string
+		status := http.StatusBadRequest
+		for i, err := range errs {
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("item %d: %v", i, err))
+				var uploadErr *uploadError
+				if errors.As(err, &uploadErr) {
+					status = http.StatusInternalServerError
+				}
+			}
+		}
+		if len(failed) > 0 {
+			s.Respond(w, r, status, errors.New("Album not sent, "+strings.Join(failed, "; ")))
+			return
+		}
+
+		albumID := t.Id
+		if albumID == "" {
+			albumID = whatsmeow.GenerateMessageID()
+		}
+		album := &waProto.Message{AlbumMessage: &waE2E.AlbumMessage{
+			ExpectedImageCount: proto.Uint32(images),
+			ExpectedVideoCount: proto.Uint32(videos),
+		}}
+		results := make([]albumResult, len(msgs))
+		for i, msg := range msgs {
+			results[i].Index = i
+			results[i].Id = whatsmeow.GenerateMessageID()
+			if msg.MessageContextInfo == nil {
+				msg.MessageContextInfo = &waE2E.MessageContextInfo{}
+			}
+			msg.MessageContextInfo.MessageAssociation = &waE2E.MessageAssociation{
+				AssociationType: waE2E.MessageAssociation_MEDIA_ALBUM.Enum(),
+				ParentMessageKey: &waProto.MessageKey{
+					RemoteJID: proto.String(recipient.String()),
+					FromMe:    proto.Bool(true),
+					ID:        proto.String(albumID),
+				},
+			}
+		}
+
+		// Queued albums keep their order, the queue sends the messages of a chat
+		// one after the other. All jobs are queued together or none is.
+		if sendAt != nil || t.Async {
+			jobid, err := s.enqueueAlbum(userid, recipient, albumID, album, msgs, results, sendAt, typing)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not queue album: %v", err)))
+				return
+			}
+
+			details := "Queued"
+			if sendAt != nil {
+				details = "Scheduled"
+			}
+			log.Info().Str("job", jobid).Str("id", albumID).Int("items", len(msgs)).Msg("Album " + strings.ToLower(details))
+			response := map[string]interface{}{"Details": details, "JobId": jobid, "Id": albumID, "Items": results}
+			if sendAt != nil {
+				response["SendAt"] = sendAt
+			}
+			responseJson, err := json.Marshal(response)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				s.Respond(w, r, http.StatusAccepted, string(responseJson))
+			}
+			return
+		}
+
+		resp, err := s.sendNow(r.Context(), userid, recipient, album, albumID, typing)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending album: %v", err)))
+			return
+		}
+
+		// The album was delivered, so failed items are reported with it and
+		// not as a failed request, which would be retried as a new album
+		sent := 0
+		for i, msg := range msgs {
+			itemResp, err := s.sendNow(r.Context(), userid, recipient, msg, results[i].Id, false)
+			if err != nil {
+				log.Warn().Err(err).Str("album", albumID).Int("item", i).Msg("Could not send album item")
+				results[i].Id = ""
+				results[i].Error = fmt.Sprintf("Error sending message: %v", err)
+				continue
+			}
+			results[i].Timestamp = &itemResp.Timestamp
+			sent++
+		}
+		status = http.StatusOK
+		if sent < len(msgs) {
+			status = http.StatusMultiStatus
+		}
+
+		log.Info().Str("id", albumID).Int("sent", sent).Int("items", len(msgs)).Msg("Album sent")
+		response := map[string]interface{}{
+			"Details":   fmt.Sprintf("Sent %d of %d items", sent, len(msgs)),
+			"Id":        albumID,
+			"Timestamp": resp.Timestamp,
+			"Items":     results,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, status, string(responseJson))
+		}
+	}
+}
+
+// Queues the album message and its items in one transaction, filling in the
+// job ids of the items, and returns the job id of the album
+func (s *server) enqueueAlbum(userid int, recipient types.JID, albumID string, album *waProto.Message, msgs []*waProto.Message, results []albumResult, sendAt *time.Time, typing bool) (string, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	jobid, err := insertQueuedMessage(tx, userid, recipient, albumID, album, sendAt, typing)
+	if err != nil {
+		return "", err
+	}
+	for i, msg := range msgs {
+		results[i].JobId, err = insertQueuedMessage(tx, userid, recipient, results[i].Id, msg, sendAt, false)
+		if err != nil {
+			return "", fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	wakeQueueWorker(userid)
+	return jobid, nil
+}
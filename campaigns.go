@@ -0,0 +1,766 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Broadcast campaigns send the same message, with per-recipient {{variables}},
+// to an audience stored in campaign_recipients. Media is uploaded once when the
+// campaign is created and the built message is reused for every recipient.
+// A runner per instance sends running campaigns within their throttle and
+// sending window, and receipts update the status of each recipient.
+
+const (
+	campaignDraft     = "draft"
+	campaignRunning   = "running"
+	campaignPaused    = "paused"
+	campaignCancelled = "cancelled"
+	campaignFinished  = "finished"
+
+	recipientPending   = "pending"
+	recipientSending   = "sending"
+	recipientSent      = "sent"
+	recipientDelivered = "delivered"
+	recipientRead      = "read"
+	recipientFailed    = "failed"
+	recipientSkipped   = "skipped"
+
+	campaignTickInterval  = time.Second
+	campaignProgressEvery = 50
+	campaignMaxRate       = 60
+)
+
+type campaignRunner struct {
+	userID int
+	token  string
+	db     *sqlx.DB
+	quit   chan struct{}
+}
+
+var (
+	campaignRunners      = make(map[int]*campaignRunner)
+	campaignRunnersMutex sync.Mutex
+)
+
+type campaignRecipient struct {
+	Phone     string
+	Variables map[string]string
+}
+
+// Campaign with the counters of its recipients, used by the endpoints and the CampaignProgress webhook
+type campaignReport struct {
+	CampaignId  string     `db:"campaign_id"`
+	Name        string     `db:"name"`
+	Type        string     `db:"message_type"`
+	Status      string     `db:"status"`
+	Rate        int        `db:"rate"`
+	WindowStart string     `db:"window_start"`
+	WindowEnd   string     `db:"window_end"`
+	CreatedAt   time.Time  `db:"created_at"`
+	StartedAt   *time.Time `db:"started_at"`
+	FinishedAt  *time.Time `db:"finished_at"`
+	Total       int
+	Pending     int
+	Sent        int
+	Delivered   int
+	Read        int
+	Failed      int
+	Skipped     int
+}
+
+type campaignRecipientReport struct {
+	Phone       string     `db:"phone"`
+	Status      string     `db:"status"`
+	Id          string     `db:"message_id"`
+	Attempts    int        `db:"attempts"`
+	Error       string     `db:"last_error"`
+	SentAt      *time.Time `db:"sent_at"`
+	DeliveredAt *time.Time `db:"delivered_at"`
+	ReadAt      *time.Time `db:"read_at"`
+}
+
+type runningCampaign struct {
+	ID          int64  `db:"id"`
+	CampaignID  string `db:"campaign_id"`
+	Message     []byte `db:"message"`
+	Rate        int    `db:"rate"`
+	WindowStart string `db:"window_start"`
+	WindowEnd   string `db:"window_end"`
+}
+
+type claimedRecipient struct {
+	ID        int64  `db:"id"`
+	Phone     string `db:"phone"`
+	Variables string `db:"variables"`
+	MessageID string `db:"message_id"`
+	Attempts  int    `db:"attempts"`
+}
+
+// Starts the campaign runner for an instance if it is not running yet
+func startCampaignRunner(db *sqlx.DB, userID int, token string) {
+	campaignRunnersMutex.Lock()
+	defer campaignRunnersMutex.Unlock()
+
+	if _, ok := campaignRunners[userID]; ok {
+		return
+	}
+	cr := &campaignRunner{userID: userID, token: token, db: db, quit: make(chan struct{})}
+	campaignRunners[userID] = cr
+	go cr.run()
+}
+
+// Stops the campaign runner for an instance, campaigns continue when it connects again
+func stopCampaignRunner(userID int) {
+	campaignRunnersMutex.Lock()
+	defer campaignRunnersMutex.Unlock()
+
+	if cr, ok := campaignRunners[userID]; ok {
+		close(cr.quit)
+		delete(campaignRunners, userID)
+	}
+}
+
+func (cr *campaignRunner) run() {
+	log.Info().Int("userid", cr.userID).Msg("Starting campaign runner")
+
+	// Recipients still marked as sending were interrupted by a restart or disconnection
+	_, err := cr.db.Exec(`UPDATE campaign_recipients SET status=$1 WHERE status=$2
+		AND campaign_id IN (SELECT id FROM campaigns WHERE user_id=$3)`, recipientPending, recipientSending, cr.userID)
+	if err != nil {
+		log.Error().Err(err).Int("userid", cr.userID).Msg("Could not requeue interrupted campaign recipients")
+	}
+
+	ticker := time.NewTicker(campaignTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cr.quit:
+			log.Info().Int("userid", cr.userID).Msg("Stopping campaign runner")
+			return
+		case <-ticker.C:
+			cr.tick()
+		}
+	}
+}
+
+// Sends the next message of every running campaign that is due and inside its sending window
+func (cr *campaignRunner) tick() {
+	client := clientPointer[cr.userID]
+	if client == nil || !client.IsConnected() || !client.IsLoggedIn() {
+		return
+	}
+
+	var campaigns []runningCampaign
+	err := cr.db.Select(&campaigns, `SELECT id, campaign_id, message, rate, window_start, window_end FROM campaigns
+		WHERE user_id=$1 AND status=$2 AND next_send_at <= NOW() ORDER BY id`, cr.userID, campaignRunning)
+	if err != nil {
+		log.Error().Err(err).Int("userid", cr.userID).Msg("Could not load running campaigns")
+		return
+	}
+	if len(campaigns) == 0 {
+		return
+	}
+
+	settings, err := getInstanceSettings(cr.db, cr.userID)
+	if err != nil {
+		log.Warn().Err(err).Int("userid", cr.userID).Msg("Could not load instance settings, using defaults")
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.Local
+	}
+
+	for _, c := range campaigns {
+		if !inSendingWindow(time.Now().In(loc), c.WindowStart, c.WindowEnd) {
+			continue
+		}
+		cr.sendNext(client, c, settings)
+	}
+}
+
+func (cr *campaignRunner) sendNext(client *whatsmeow.Client, c runningCampaign, settings instanceSettings) {
+	var rcpt claimedRecipient
+	err := cr.db.Get(&rcpt, `
+		UPDATE campaign_recipients SET status=$2
+		WHERE id = (
+			SELECT id FROM campaign_recipients
+			WHERE campaign_id=$1 AND status=$3 AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, phone, variables, message_id, attempts`, c.ID, recipientSending, recipientPending)
+	if errors.Is(err, sql.ErrNoRows) {
+		cr.finishIfDone(c)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("campaign", c.CampaignID).Msg("Could not fetch next campaign recipient")
+		return
+	}
+
+	// Keep the same message id across retries so receipts can always be matched
+	if rcpt.MessageID == "" {
+		rcpt.MessageID = whatsmeow.GenerateMessageID()
+		if _, err := cr.db.Exec(`UPDATE campaign_recipients SET message_id=$1 WHERE id=$2`, rcpt.MessageID, rcpt.ID); err != nil {
+			log.Error().Err(err).Str("campaign", c.CampaignID).Msg("Could not store campaign message id")
+		}
+	}
+
+	// Campaign messages share the pace of the instance with the outbound queue
+	if wait := reserveSendSlot(cr.userID, settings.QueueRate); wait > 0 {
+		select {
+		case <-cr.quit:
+			if _, err := cr.db.Exec(`UPDATE campaign_recipients SET status=$1 WHERE id=$2`, recipientPending, rcpt.ID); err != nil {
+				log.Error().Err(err).Str("campaign", c.CampaignID).Msg("Could not release campaign recipient")
+			}
+			return
+		case <-time.After(wait):
+		}
+	}
+
+	attempts := rcpt.Attempts + 1
+	sendErr := cr.send(client, c, rcpt)
+	switch {
+	case sendErr == nil:
+		_, err = cr.db.Exec(`UPDATE campaign_recipients SET status=$1, attempts=$2, last_error='', sent_at=NOW() WHERE id=$3`,
+			recipientSent, attempts, rcpt.ID)
+	case isTransientSendError(sendErr) && attempts < *queueRetries:
+		backoff := time.Duration(attempts*attempts) * queueRetryDelay
+		log.Warn().Err(sendErr).Str("campaign", c.CampaignID).Str("phone", rcpt.Phone).Msg("Campaign message failed, will retry")
+		_, err = cr.db.Exec(`UPDATE campaign_recipients SET status=$1, attempts=$2, last_error=$3, next_attempt_at=NOW() + $4 * INTERVAL '1 second' WHERE id=$5`,
+			recipientPending, attempts, sendErr.Error(), int(backoff.Seconds()), rcpt.ID)
+	default:
+		log.Error().Err(sendErr).Str("campaign", c.CampaignID).Str("phone", rcpt.Phone).Msg("Campaign message failed")
+		_, err = cr.db.Exec(`UPDATE campaign_recipients SET status=$1, attempts=$2, last_error=$3 WHERE id=$4`,
+			recipientFailed, attempts, sendErr.Error(), rcpt.ID)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("campaign", c.CampaignID).Msg("Could not update campaign recipient")
+	}
+
+	// Throttle: the campaign is due again after its interval plus the instance jitter
+	wait := time.Minute / time.Duration(c.Rate)
+	if settings.QueueJitter > 0 {
+		if n, err := rand.Int(rand.Reader, big.NewInt(int64(settings.QueueJitter)+1)); err == nil {
+			wait += time.Duration(n.Int64()) * time.Millisecond
+		}
+	}
+	_, err = cr.db.Exec(`UPDATE campaigns SET next_send_at=NOW() + $1 * INTERVAL '1 millisecond', updated_at=NOW() WHERE id=$2`,
+		wait.Milliseconds(), c.ID)
+	if err != nil {
+		log.Error().Err(err).Str("campaign", c.CampaignID).Msg("Could not update campaign")
+	}
+
+	report, err := getCampaignReport(cr.db, cr.userID, c.CampaignID)
+	if err == nil && (report.Total-report.Pending)%campaignProgressEvery == 0 {
+		sendEventWebhook(cr.userID, cr.token, "CampaignProgress", report)
+	}
+}
+
+func (cr *campaignRunner) send(client *whatsmeow.Client, c runningCampaign, rcpt claimedRecipient) error {
+	recipient, err := types.ParseJID(rcpt.Phone)
+	if err != nil {
+		return err
+	}
+
+	msg := &waProto.Message{}
+	if err := proto.Unmarshal(c.Message, msg); err != nil {
+		return err
+	}
+
+	vars := map[string]string{}
+	if err := json.Unmarshal([]byte(rcpt.Variables), &vars); err != nil {
+		return err
+	}
+	if _, ok := vars["phone"]; !ok {
+		vars["phone"] = recipient.User
+	}
+	renderMessageVariables(msg, vars)
+
+	ctx, cancel := context.WithTimeout(context.Background(), queueSendTimeout)
+	defer cancel()
+	resp, err := client.SendMessage(ctx, recipient, msg, sendRequestExtra(msg, rcpt.MessageID))
+	if err != nil {
+		return err
+	}
+	log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", rcpt.MessageID).Str("campaign", c.CampaignID).Msg("Campaign message sent")
+	storeSentMessage(cr.db, cr.userID, client, recipient, rcpt.MessageID, msg, resp.Timestamp)
+	return nil
+}
+
+// Marks a running campaign as finished once no recipient is waiting to be sent
+func (cr *campaignRunner) finishIfDone(c runningCampaign) {
+	var waiting int
+	err := cr.db.Get(&waiting, `SELECT COUNT(*) FROM campaign_recipients WHERE campaign_id=$1 AND status IN ($2, $3)`,
+		c.ID, recipientPending, recipientSending)
+	if err != nil || waiting > 0 {
+		return
+	}
+
+	result, err := cr.db.Exec(`UPDATE campaigns SET status=$1, finished_at=NOW(), updated_at=NOW() WHERE id=$2 AND status=$3`,
+		campaignFinished, c.ID, campaignRunning)
+	if err != nil {
+		log.Error().Err(err).Str("campaign", c.CampaignID).Msg("Could not finish campaign")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		log.Info().Str("campaign", c.CampaignID).Msg("Campaign finished")
+		if report, err := getCampaignReport(cr.db, cr.userID, c.CampaignID); err == nil {
+			sendEventWebhook(cr.userID, cr.token, "CampaignProgress", report)
+		}
+	}
+}
+
+// Checks a "15:04" daily window, windows ending before they start go over midnight
+func inSendingWindow(now time.Time, start string, end string) bool {
+	if start == "" || end == "" {
+		return true
+	}
+	current := now.Format("15:04")
+	if start <= end {
+		return current >= start && current < end
+	}
+	return current >= start || current < end
+}
+
+// Updates campaign recipients with delivery and read receipts
+func trackCampaignReceipt(db *sqlx.DB, userID int, evt *events.Receipt) {
+	var status, column string
+	switch evt.Type {
+	case types.ReceiptTypeDelivered:
+		status, column = recipientDelivered, "delivered_at"
+	case types.ReceiptTypeRead, types.ReceiptTypePlayed:
+		status, column = recipientRead, "read_at"
+	default:
+		return
+	}
+
+	ids := make([]string, len(evt.MessageIDs))
+	for i, id := range evt.MessageIDs {
+		ids[i] = string(id)
+	}
+
+	// Never go back from read to delivered, receipts may arrive out of order
+	allowed := []string{recipientSent, recipientDelivered}
+	if status == recipientDelivered {
+		allowed = []string{recipientSent}
+	}
+
+	_, err := db.Exec(fmt.Sprintf(`UPDATE campaign_recipients SET status=$1, %s=$2
+		WHERE message_id = ANY($3) AND status = ANY($4)
+		AND campaign_id IN (SELECT id FROM campaigns WHERE user_id=$5)`, column),
+		status, evt.Timestamp, pq.Array(ids), pq.Array(allowed), userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Could not update campaign receipts")
+	}
+}
+
+func getCampaignReport(db *sqlx.DB, userID int, campaignID string) (campaignReport, error) {
+	var report campaignReport
+	err := db.Get(&report, `SELECT campaign_id, name, message_type, status, rate, window_start, window_end, created_at, started_at, finished_at
+		FROM campaigns WHERE user_id=$1 AND campaign_id=$2`, userID, campaignID)
+	if err != nil {
+		return report, err
+	}
+
+	var counts []struct {
+		Status string `db:"status"`
+		Count  int    `db:"count"`
+	}
+	err = db.Select(&counts, `SELECT r.status, COUNT(*) AS count FROM campaign_recipients r
+		JOIN campaigns c ON c.id = r.campaign_id WHERE c.campaign_id=$1 GROUP BY r.status`, campaignID)
+	if err != nil {
+		return report, err
+	}
+
+	for _, c := range counts {
+		report.Total += c.Count
+		switch c.Status {
+		case recipientPending, recipientSending:
+			report.Pending += c.Count
+		case recipientSent:
+			report.Sent += c.Count
+		case recipientDelivered:
+			report.Delivered += c.Count
+		case recipientRead:
+			report.Read += c.Count
+		case recipientFailed:
+			report.Failed += c.Count
+		case recipientSkipped:
+			report.Skipped += c.Count
+		}
+	}
+	return report, nil
+}
+
+// Stores recipients of a campaign, phones already in the audience are ignored
+func addCampaignRecipients(db *sqlx.DB, campaignID int64, recipients []campaignRecipient) (int, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Preparex(`INSERT INTO campaign_recipients (campaign_id, phone, variables) VALUES ($1, $2, $3)
+		ON CONFLICT (campaign_id, phone) DO NOTHING`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	added := 0
+	for i, rcpt := range recipients {
+		if rcpt.Phone == "" {
+			return 0, fmt.Errorf("Missing Phone in recipient %d", i)
+		}
+		jid, ok := parseJID(rcpt.Phone)
+		if !ok {
+			return 0, fmt.Errorf("Could not parse Phone %s", rcpt.Phone)
+		}
+		vars := rcpt.Variables
+		if vars == nil {
+			vars = map[string]string{}
+		}
+		varsJson, err := json.Marshal(vars)
+		if err != nil {
+			return 0, err
+		}
+		result, err := stmt.Exec(campaignID, jid.String(), string(varsJson))
+		if err != nil {
+			return 0, err
+		}
+		rows, _ := result.RowsAffected()
+		added += int(rows)
+	}
+
+	return added, tx.Commit()
+}
+
+func (s *server) respondCampaign(w http.ResponseWriter, r *http.Request, userid int, campaignID string) {
+	report, err := getCampaignReport(s.db, userid, campaignID)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.Respond(w, r, http.StatusNotFound, errors.New("Campaign not found"))
+		return
+	}
+	if err != nil {
+		s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not get campaign: %v", err)))
+		return
+	}
+
+	responseJson, err := json.Marshal(report)
+	if err != nil {
+		s.Respond(w, r, http.StatusInternalServerError, err)
+	} else {
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Creates a campaign in draft state
+func (s *server) CreateCampaign() http.HandlerFunc {
+
+	type campaignStruct struct {
+		Name        string
+		Message     messageContent
+		Recipients  []campaignRecipient
+		Rate        int
+		WindowStart string
+		WindowEnd   string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t campaignStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Name in Payload"))
+			return
+		}
+
+		if t.Rate == 0 {
+			// The instance rate has no upper bound, campaigns are capped
+			settings, _ := getInstanceSettings(s.db, userid)
+			t.Rate = min(settings.QueueRate, campaignMaxRate)
+		} else if t.Rate < 1 || t.Rate > campaignMaxRate {
+			s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("Rate must be between 1 and %d messages per minute", campaignMaxRate)))
+			return
+		}
+
+		for _, v := range []string{t.WindowStart, t.WindowEnd} {
+			if _, err := time.Parse("15:04", v); v != "" && err != nil {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("WindowStart and WindowEnd should be in HH:MM format"))
+				return
+			}
+		}
+		if (t.WindowStart == "") != (t.WindowEnd == "") {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("WindowStart and WindowEnd must be set together"))
+			return
+		}
+
+		msg, err := buildMessage(r.Context(), clientPointer[userid], t.Message)
+		if err != nil {
+			var uerr *uploadError
+			if errors.As(err, &uerr) {
+				s.Respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				s.Respond(w, r, http.StatusBadRequest, err)
+			}
+			return
+		}
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		campaignID, err := generateJobID()
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		var id int64
+		err = s.db.Get(&id, `INSERT INTO campaigns (campaign_id, user_id, name, message, message_type, rate, window_start, window_end)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+			campaignID, userid, t.Name, data, t.Message.Type, t.Rate, t.WindowStart, t.WindowEnd)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not create campaign: %v", err)))
+			return
+		}
+
+		if _, err := addCampaignRecipients(s.db, id, t.Recipients); err != nil {
+			s.db.Exec(`DELETE FROM campaigns WHERE id=$1`, id)
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		log.Info().Str("campaign", campaignID).Int("recipients", len(t.Recipients)).Msg("Campaign created")
+		s.respondCampaign(w, r, userid, campaignID)
+	}
+}
+
+// Adds recipients to a campaign that is not running
+func (s *server) AddCampaignRecipients() http.HandlerFunc {
+
+	type recipientsStruct struct {
+		Recipients []campaignRecipient
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+		campaignID := mux.Vars(r)["id"]
+
+		decoder := json.NewDecoder(r.Body)
+		var t recipientsStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+		if len(t.Recipients) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Recipients in Payload"))
+			return
+		}
+
+		var campaign struct {
+			ID     int64  `db:"id"`
+			Status string `db:"status"`
+		}
+		err = s.db.Get(&campaign, `SELECT id, status FROM campaigns WHERE user_id=$1 AND campaign_id=$2`, userid, campaignID)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Campaign not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if campaign.Status != campaignDraft && campaign.Status != campaignPaused {
+			s.Respond(w, r, http.StatusConflict, errors.New("Recipients can only be added to draft or paused campaigns"))
+			return
+		}
+
+		if _, err := addCampaignRecipients(s.db, campaign.ID, t.Recipients); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		s.respondCampaign(w, r, userid, campaignID)
+	}
+}
+
+// Lists the campaigns of the instance
+func (s *server) ListCampaigns() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		var ids []string
+		err := s.db.Select(&ids, `SELECT campaign_id FROM campaigns WHERE user_id=$1 ORDER BY id DESC`, userid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list campaigns: %v", err)))
+			return
+		}
+
+		reports := []campaignReport{}
+		for _, id := range ids {
+			report, err := getCampaignReport(s.db, userid, id)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list campaigns: %v", err)))
+				return
+			}
+			reports = append(reports, report)
+		}
+
+		responseJson, err := json.Marshal(reports)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Gets a campaign with its progress counters
+func (s *server) GetCampaign() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		s.respondCampaign(w, r, userid, mux.Vars(r)["id"])
+	}
+}
+
+// Gets the result of every recipient of a campaign, optionally filtered by ?status=
+func (s *server) GetCampaignReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+		campaignID := mux.Vars(r)["id"]
+
+		report, err := getCampaignReport(s.db, userid, campaignID)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Campaign not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		recipients := []campaignRecipientReport{}
+		err = s.db.Select(&recipients, `SELECT r.phone, r.status, r.message_id, r.attempts, r.last_error, r.sent_at, r.delivered_at, r.read_at
+			FROM campaign_recipients r JOIN campaigns c ON c.id = r.campaign_id
+			WHERE c.campaign_id=$1 AND ($2 = '' OR r.status = $2) ORDER BY r.id`,
+			campaignID, r.URL.Query().Get("status"))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		response := map[string]interface{}{"Campaign": report, "Recipients": recipients}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Changes the state of a campaign: start, pause, resume or cancel
+func (s *server) ChangeCampaignState(action string) http.HandlerFunc {
+
+	// Allowed source states and resulting state for each action
+	transitions := map[string]struct {
+		from []string
+		to   string
+	}{
+		"start":  {[]string{campaignDraft}, campaignRunning},
+		"pause":  {[]string{campaignRunning}, campaignPaused},
+		"resume": {[]string{campaignPaused}, campaignRunning},
+		"cancel": {[]string{campaignDraft, campaignRunning, campaignPaused}, campaignCancelled},
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+		token := r.Context().Value("userinfo").(Values).Get("Token")
+		campaignID := mux.Vars(r)["id"]
+
+		transition := transitions[action]
+		result, err := s.db.Exec(`UPDATE campaigns SET status=$1, updated_at=NOW(),
+			started_at = CASE WHEN $1 = $5::text AND started_at IS NULL THEN NOW() ELSE started_at END,
+			finished_at = CASE WHEN $1 = $6::text THEN NOW() ELSE finished_at END
+			WHERE user_id=$2 AND campaign_id=$3 AND status = ANY($4)`,
+			transition.to, userid, campaignID, pq.Array(transition.from), campaignRunning, campaignCancelled)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not %s campaign: %v", action, err)))
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			if _, err := getCampaignReport(s.db, userid, campaignID); errors.Is(err, sql.ErrNoRows) {
+				s.Respond(w, r, http.StatusNotFound, errors.New("Campaign not found"))
+			} else {
+				s.Respond(w, r, http.StatusConflict, errors.New(fmt.Sprintf("Campaign cannot %s from its current state", action)))
+			}
+			return
+		}
+
+		if transition.to == campaignCancelled {
+			_, err = s.db.Exec(`UPDATE campaign_recipients SET status=$1 WHERE status IN ($2, $3)
+				AND campaign_id = (SELECT id FROM campaigns WHERE user_id=$4 AND campaign_id=$5)`,
+				recipientSkipped, recipientPending, recipientSending, userid, campaignID)
+			if err != nil {
+				log.Error().Err(err).Str("campaign", campaignID).Msg("Could not skip pending campaign recipients")
+			}
+		}
+
+		log.Info().Str("campaign", campaignID).Str("status", transition.to).Msg("Campaign state changed")
+		if report, err := getCampaignReport(s.db, userid, campaignID); err == nil {
+			sendEventWebhook(userid, token, "CampaignProgress", report)
+		}
+		s.respondCampaign(w, r, userid, campaignID)
+	}
+}
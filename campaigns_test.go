@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInSendingWindow(t *testing.T) {
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return t
+	}
+
+	tests := []struct {
+		now        string
+		start, end string
+		want       bool
+	}{
+		{"03:00", "", "", true},
+		{"09:00", "09:00", "18:00", true},
+		{"17:59", "09:00", "18:00", true},
+		{"18:00", "09:00", "18:00", false},
+		{"08:59", "09:00", "18:00", false},
+		{"23:00", "22:00", "06:00", true},
+		{"05:59", "22:00", "06:00", true},
+		{"06:00", "22:00", "06:00", false},
+		{"12:00", "22:00", "06:00", false},
+	}
+	for _, test := range tests {
+		if got := inSendingWindow(at(test.now), test.start, test.end); got != test.want {
+			t.Errorf("inSendingWindow(%s, %q, %q) = %v, want %v", test.now, test.start, test.end, got, test.want)
+		}
+	}
+}
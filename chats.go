@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/appstate"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waSyncAction"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Archive, pin, mute, star and delete for me are app state changes. They are
+// sent as patches, which the other devices of the account apply too.
+
+// Payload of the chat management endpoints
+type chatActionStruct struct {
+	Phone string // chat: phone number, user or group JID
+
+	Archive bool   // /chat/archive, false to unarchive
+	Pin     bool   // /chat/pin, false to unpin
+	Mute    bool   // /chat/mute, false to unmute
+	Until   string // /chat/mute, 8h, 7d or always (default)
+
+	Id          string // /chat/star and /chat/deleteforme, message id
+	Star        bool   // /chat/star, false to unstar
+	FromMe      bool   // sender of the message, only needed when it is not in the message store
+	Participant string
+	DeleteMedia bool // /chat/deleteforme, also delete the downloaded media
+}
+
+// Returns the last message of a chat known to the message store, used as
+// the message range of archive patches
+func (s *server) lastChatMessage(userid int, chat types.JID) (time.Time, *waProto.MessageKey) {
+	var row struct {
+		ID        string    `db:"message_id"`
+		Sender    string    `db:"sender"`
+		FromMe    bool      `db:"from_me"`
+		Timestamp time.Time `db:"timestamp"`
+	}
+	err := s.db.Get(&row, `SELECT message_id, sender, from_me, timestamp FROM message_store
+		WHERE user_id=$1 AND chat=$2 ORDER BY timestamp DESC LIMIT 1`, userid, chat.String())
+	if err != nil {
+		return time.Time{}, nil
+	}
+	key := &waProto.MessageKey{
+		RemoteJID: proto.String(chat.String()),
+		FromMe:    proto.Bool(row.FromMe),
+		ID:        proto.String(row.ID),
+	}
+	if chat.Server == types.GroupServer && !row.FromMe {
+		key.Participant = proto.String(row.Sender)
+	}
+	return row.Timestamp, key
+}
+
+// Finds who sent a message, from the message store or the payload. Returns
+// the sender as used by app state indexes, which is the chat itself for
+// direct chats and messages sent by the instance.
+func (s *server) messageSender(userid int, chat types.JID, t chatActionStruct) (types.JID, bool, time.Time, error) {
+	stored, err := loadStoredMessage(s.db, userid, t.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Warn().Err(err).Str("id", t.Id).Msg("Could not load message")
+	}
+	if err == nil {
+		if stored.FromMe || chat.Server != types.GroupServer {
+			return chat, stored.FromMe, stored.Timestamp, nil
+		}
+		return stored.Sender, false, stored.Timestamp, nil
+	}
+
+	if t.FromMe || chat.Server != types.GroupServer {
+		return chat, t.FromMe, time.Time{}, nil
+	}
+	if t.Participant == "" {
+		return chat, false, time.Time{}, errors.New("Message not found, Participant is required for group messages")
+	}
+	participant, ok := parseJID(t.Participant)
+	if !ok {
+		return chat, false, time.Time{}, errors.New("Could not parse Participant")
+	}
+	return participant, false, time.Time{}, nil
+}
+
+// Builds the patch to delete a message for the instance only
+func buildDeleteForMe(chat, sender types.JID, id types.MessageID, fromMe bool, deleteMedia bool, timestamp time.Time) appstate.PatchInfo {
+	isFromMe, senderJID := "0", "0"
+	if fromMe {
+		isFromMe = "1"
+	}
+	if sender.User != chat.User {
+		senderJID = sender.String()
+	}
+	action := &waSyncAction.DeleteMessageForMeAction{DeleteMedia: proto.Bool(deleteMedia)}
+	if !timestamp.IsZero() {
+		action.MessageTimestamp = proto.Int64(timestamp.Unix())
+	}
+	return appstate.PatchInfo{
+		Type: appstate.WAPatchRegularHigh,
+		Mutations: []appstate.MutationInfo{{
+			Index:   []string{appstate.IndexDeleteMessageForMe, chat.String(), id, isFromMe, senderJID},
+			Version: 3,
+			Value:   &waSyncAction.SyncActionValue{DeleteMessageForMeAction: action},
+		}},
+	}
+}
+
+// Returns the handler of a chat management endpoint. The action builds the
+// patch from the payload and the chat.
+func (s *server) chatAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t chatActionStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
+			return
+		}
+		chat, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Phone"))
+			return
+		}
+
+		var patch appstate.PatchInfo
+		var details string
+		switch action {
+		case "archive":
+			timestamp, key := s.lastChatMessage(userid, chat)
+			patch = appstate.BuildArchive(chat, t.Archive, timestamp, key)
+			details = map[bool]string{true: "Chat archived", false: "Chat unarchived"}[t.Archive]
+		case "pin":
+			patch = appstate.BuildPin(chat, t.Pin)
+			details = map[bool]string{true: "Chat pinned", false: "Chat unpinned"}[t.Pin]
+		case "mute":
+			var duration time.Duration
+			switch t.Until {
+			case "8h":
+				duration = 8 * time.Hour
+			case "7d":
+				duration = 7 * 24 * time.Hour
+			case "", "always":
+				duration = 0
+			default:
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Invalid Until. Use: 8h, 7d or always"))
+				return
+			}
+			patch = appstate.BuildMute(chat, t.Mute, duration)
+			details = map[bool]string{true: "Chat muted", false: "Chat unmuted"}[t.Mute]
+		case "star", "deleteforme":
+			if t.Id == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Id in Payload"))
+				return
+			}
+			sender, fromMe, timestamp, err := s.messageSender(userid, chat, t)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+			if action == "star" {
+				patch = appstate.BuildStar(chat, sender, t.Id, fromMe, t.Star)
+				details = map[bool]string{true: "Message starred", false: "Message unstarred"}[t.Star]
+			} else {
+				patch = buildDeleteForMe(chat, sender, t.Id, fromMe, t.DeleteMedia, timestamp)
+				details = "Message deleted for me"
+			}
+		}
+
+		err = clientPointer[userid].SendAppState(r.Context(), patch)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not send app state patch: %v", err)))
+			return
+		}
+
+		log.Info().Str("action", action).Str("chat", chat.String()).Str("id", t.Id).Msg(details)
+		response := map[string]interface{}{"Details": details}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Archives or unarchives a chat
+func (s *server) ArchiveChat() http.HandlerFunc {
+	return s.chatAction("archive")
+}
+
+// Pins or unpins a chat
+func (s *server) PinChat() http.HandlerFunc {
+	return s.chatAction("pin")
+}
+
+// Mutes or unmutes a chat
+func (s *server) MuteChat() http.HandlerFunc {
+	return s.chatAction("mute")
+}
+
+// Stars or unstars a message
+func (s *server) StarMessage() http.HandlerFunc {
+	return s.chatAction("star")
+}
+
+// Deletes a message for the instance only
+func (s *server) DeleteForMe() http.HandlerFunc {
+	return s.chatAction("deleteforme")
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Communities are parent groups. Groups are linked to them as subgroups,
+// and the server creates an announcement group for every community, which
+// is the default subgroup and where only admins can post.
+
+// Returns the announcement group of a community
+func announcementGroup(subgroups []*types.GroupLinkTarget) (types.JID, bool) {
+	for _, subgroup := range subgroups {
+		if subgroup.IsDefaultSubGroup {
+			return subgroup.JID, true
+		}
+	}
+	return types.EmptyJID, false
+}
+
+// Creates a community
+func (s *server) CreateCommunity() http.HandlerFunc {
+
+	type createCommunityStruct struct {
+		Name string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t createCommunityStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Name in Payload"))
+			return
+		}
+
+		req := whatsmeow.ReqCreateGroup{Name: t.Name}
+		req.IsParent = true
+
+		info, err := clientPointer[userid].CreateGroup(r.Context(), req)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to create community")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to create community: %v", err)))
+			return
+		}
+
+		log.Info().Str("jid", info.JID.String()).Msg("Community created")
+		responseJson, err := json.Marshal(info)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Returns the handler that links a group to a community, or unlinks it
+func (s *server) communityLink(link bool) http.HandlerFunc {
+
+	type communityLinkStruct struct {
+		CommunityJID string
+		GroupJID     string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t communityLinkStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.CommunityJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing CommunityJID in Payload"))
+			return
+		}
+		community, ok := parseJID(t.CommunityJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Community JID"))
+			return
+		}
+		if t.GroupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing GroupJID in Payload"))
+			return
+		}
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+			return
+		}
+
+		var details string
+		if link {
+			err = clientPointer[userid].LinkGroup(r.Context(), community, group)
+			details = "Group linked to community"
+		} else {
+			err = clientPointer[userid].UnlinkGroup(r.Context(), community, group)
+			details = "Group unlinked from community"
+		}
+		if err != nil {
+			log.Error().Err(err).Str("community", community.String()).Str("group", group.String()).Bool("link", link).Msg("Failed to change community subgroups")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to change community subgroups: %v", err)))
+			return
+		}
+
+		log.Info().Str("community", community.String()).Str("group", group.String()).Msg(details)
+		response := map[string]interface{}{"Details": details}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Links an existing group to a community
+func (s *server) LinkCommunityGroup() http.HandlerFunc {
+	return s.communityLink(true)
+}
+
+// Unlinks a group from a community
+func (s *server) UnlinkCommunityGroup() http.HandlerFunc {
+	return s.communityLink(false)
+}
+
+// Returns the handler of the community listings, which read the community
+// from the communityJID query parameter
+func (s *server) communityListing(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		communityJID := r.URL.Query().Get("communityJID")
+		if communityJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing communityJID parameter"))
+			return
+		}
+		community, ok := parseJID(communityJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Community JID"))
+			return
+		}
+
+		response := map[string]interface{}{"CommunityJID": community.String()}
+		switch list {
+		case "subgroups":
+			subgroups, err := clientPointer[userid].GetSubGroups(r.Context(), community)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to get community subgroups: %v", err)))
+				return
+			}
+			if subgroups == nil {
+				subgroups = []*types.GroupLinkTarget{}
+			}
+			response["Subgroups"] = subgroups
+			if announcement, ok := announcementGroup(subgroups); ok {
+				response["AnnouncementJID"] = announcement
+			}
+		case "participants":
+			participants, err := clientPointer[userid].GetLinkedGroupsParticipants(r.Context(), community)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to get community participants: %v", err)))
+				return
+			}
+			if participants == nil {
+				participants = []types.JID{}
+			}
+			response["Participants"] = participants
+		}
+
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Lists the subgroups of a community, including its announcement group
+func (s *server) GetCommunitySubgroups() http.HandlerFunc {
+	return s.communityListing("subgroups")
+}
+
+// Lists the participants of all groups of a community
+func (s *server) GetCommunityParticipants() http.HandlerFunc {
+	return s.communityListing("participants")
+}
+
+// Posts a message to the announcement group of a community. Takes the same
+// envelope as /chat/send, with the community instead of the Phone.
+func (s *server) SendCommunityAnnouncement() http.HandlerFunc {
+
+	type announcementStruct struct {
+		CommunityJID string
+		sendRequest
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t announcementStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.CommunityJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing CommunityJID in Payload"))
+			return
+		}
+		community, ok := parseJID(t.CommunityJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Community JID"))
+			return
+		}
+		subgroups, err := clientPointer[userid].GetSubGroups(r.Context(), community)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to get community subgroups: %v", err)))
+			return
+		}
+		announcement, ok := announcementGroup(subgroups)
+		if !ok {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Community has no announcement group"))
+			return
+		}
+
+		t.Phone = announcement.String()
+		t.Group = ""
+		if t.Type == "" {
+			t.Type = "text"
+		}
+		s.sendContent(w, r, userid, &t.sendRequest)
+	}
+}
@@ -8,10 +8,18 @@ var supportedEventTypes = []string{
 	"Receipt",
 	"MediaRetry",
 	"ReadReceipt",
+	"PollVote",
+	"InteractiveReply",
 
 	// Groups and Contacts
 	"GroupInfo",
 	"JoinedGroup",
+	"GroupJoinRequest",
+	"GroupParticipantsUpdate",
+	"GroupSubjectChange",
+	"GroupDescriptionChange",
+	"GroupSettingsChange",
+	"GroupModeration",
 	"Picture",
 	"BlocklistChange",
 	"Blocklist",
@@ -44,6 +52,13 @@ var supportedEventTypes = []string{
 	"OfflineSyncCompleted",
 	"OfflineSyncPreview",
 
+	// Chat management from other devices
+	"Archive",
+	"Pin",
+	"Mute",
+	"Star",
+	"DeleteForMe",
+
 	// Calls
 	"CallOffer",
 	"CallAccept",
@@ -70,6 +85,10 @@ var supportedEventTypes = []string{
 	// Facebook/Meta Bridge
 	"FBMessage",
 
+	// Outbound queue and campaigns
+	"SendJob",
+	"CampaignProgress",
+
 	// Special - receives all events
 	"All",
 }
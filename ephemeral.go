@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// The disappearing timer of every chat is learned from group info, from the
+// ephemeral setting messages sent when it changes and from the expiration
+// of incoming messages, so outgoing messages can follow it like the
+// official clients do.
+
+// Checks a disappearing timer given in seconds
+func validEphemeralExpiration(seconds uint32) error {
+	switch time.Duration(seconds) * time.Second {
+	case whatsmeow.DisappearingTimer24Hours, whatsmeow.DisappearingTimer7Days, whatsmeow.DisappearingTimer90Days:
+		return nil
+	}
+	return fmt.Errorf("EphemeralExpiration must be %d, %d or %d seconds", uint32(whatsmeow.DisappearingTimer24Hours.Seconds()),
+		uint32(whatsmeow.DisappearingTimer7Days.Seconds()), uint32(whatsmeow.DisappearingTimer90Days.Seconds()))
+}
+
+// Keeps the disappearing timer of a chat, 0 when it is off
+func setEphemeralTimer(db *sqlx.DB, userID int, chat types.JID, timer uint32) {
+	_, err := db.Exec(`INSERT INTO chat_ephemeral (user_id, chat, timer, updated_at) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id, chat) DO UPDATE SET timer=EXCLUDED.timer, updated_at=EXCLUDED.updated_at`,
+		userID, chat.ToNonAD().String(), timer)
+	if err != nil {
+		log.Error().Err(err).Str("chat", chat.String()).Msg("Could not store disappearing timer")
+	}
+}
+
+// Returns the known disappearing timer of a chat
+func getEphemeralTimer(db *sqlx.DB, userID int, chat types.JID) (uint32, bool) {
+	var timer uint32
+	err := db.Get(&timer, "SELECT timer FROM chat_ephemeral WHERE user_id=$1 AND chat=$2", userID, chat.ToNonAD().String())
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Warn().Err(err).Str("chat", chat.String()).Msg("Could not load disappearing timer")
+		}
+		return 0, false
+	}
+	return timer, true
+}
+
+// Learns the disappearing timer of a chat from a message
+func learnEphemeralTimer(db *sqlx.DB, userID int, evt *events.Message) {
+	if evt.Message == nil || evt.Info.Chat == types.StatusBroadcastJID {
+		return
+	}
+	if protocol := evt.Message.GetProtocolMessage(); protocol != nil {
+		if protocol.GetType() == waProto.ProtocolMessage_EPHEMERAL_SETTING {
+			setEphemeralTimer(db, userID, evt.Info.Chat, protocol.GetEphemeralExpiration())
+		}
+		return
+	}
+	// Messages without expiration can come from clients that do not know
+	// the timer yet, so only a set one is trusted
+	_, _, _, contextInfo := describeContent(evt.Message)
+	if expiration := contextInfo.GetExpiration(); expiration > 0 {
+		if known, _ := getEphemeralTimer(db, userID, evt.Info.Chat); known != expiration {
+			setEphemeralTimer(db, userID, evt.Info.Chat, expiration)
+		}
+	}
+}
+
+// Learns the disappearing timer of a group from its info
+func learnGroupEphemeral(db *sqlx.DB, userID int, group types.JID, ephemeral types.GroupEphemeral) {
+	timer := uint32(0)
+	if ephemeral.IsEphemeral {
+		timer = ephemeral.DisappearingTimer
+	}
+	setEphemeralTimer(db, userID, group, timer)
+}
+
+// Returns the disappearing timer to use in a chat when it is not known yet.
+// Groups are asked for their setting, other chats fall back to 7 days.
+func (s *server) disappearingTimer(ctx context.Context, userid int, chat types.JID) uint32 {
+	if timer, ok := getEphemeralTimer(s.db, userid, chat); ok && timer > 0 {
+		return timer
+	}
+	if chat.Server == types.GroupServer {
+		info, err := clientPointer[userid].GetGroupInfo(ctx, chat)
+		if err == nil {
+			learnGroupEphemeral(s.db, userid, chat, info.GroupEphemeral)
+			if info.IsEphemeral && info.DisappearingTimer > 0 {
+				return info.DisappearingTimer
+			}
+		}
+	}
+	return uint32(whatsmeow.DisappearingTimer7Days.Seconds())
+}
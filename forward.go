@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Messages are forwarded by sending the stored message again, media keys
+// included, so nothing is downloaded or uploaded.
+
+type forwardResult struct {
+	Phone     string
+	Id        string     `json:",omitempty"`
+	Timestamp *time.Time `json:",omitempty"`
+	Error     string     `json:",omitempty"`
+}
+
+// Returns the copy of a stored message sent when forwarding it
+func forwardContent(msg *waProto.Message) (*waProto.Message, error) {
+	if msg.ViewOnceMessage != nil || msg.ViewOnceMessageV2 != nil || msg.GetImageMessage().GetViewOnce() || msg.GetVideoMessage().GetViewOnce() || msg.GetAudioMessage().GetViewOnce() {
+		return nil, errors.New("View once messages can not be forwarded")
+	}
+	if msg.PollCreationMessage != nil || msg.PollCreationMessageV2 != nil || msg.PollCreationMessageV3 != nil {
+		return nil, errors.New("Polls can not be forwarded")
+	}
+
+	forwarded := proto.Clone(msg).(*waProto.Message)
+	forwarded.MessageContextInfo = nil
+	if forwarded.Conversation != nil {
+		forwarded.ExtendedTextMessage = &waProto.ExtendedTextMessage{Text: forwarded.Conversation}
+		forwarded.Conversation = nil
+	}
+
+	contextInfo := contextInfoOf(forwarded)
+	if contextInfo == nil {
+		return nil, errors.New("Message type can not be forwarded")
+	}
+	score := contextInfo.GetForwardingScore()
+	if contextInfo.GetIsForwarded() || score > 0 {
+		score++
+	} else {
+		score = 1
+	}
+	proto.Reset(contextInfo)
+	contextInfo.IsForwarded = proto.Bool(true)
+	contextInfo.ForwardingScore = proto.Uint32(score)
+	return forwarded, nil
+}
+
+// Forwards a stored message to one or more chats
+func (s *server) Forward() http.HandlerFunc {
+
+	type forwardStruct struct {
+		MessageId  string
+		SourceChat string   // optional, checked against the chat the message belongs to
+		To         []string // phone numbers, user or group JIDs
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t forwardStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+		if t.MessageId == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing MessageId in Payload"))
+			return
+		}
+		if len(t.To) == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing To in Payload"))
+			return
+		}
+
+		stored, err := loadStoredMessage(s.db, userid, t.MessageId)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Message not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not load message: %v", err)))
+			return
+		}
+		if t.SourceChat != "" {
+			source, ok := parseJID(t.SourceChat)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse SourceChat"))
+				return
+			}
+			if source.ToNonAD() != stored.Chat.ToNonAD() {
+				s.Respond(w, r, http.StatusNotFound, errors.New("Message not found in SourceChat"))
+				return
+			}
+		}
+
+		msg, err := forwardContent(stored.Message)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		results := []forwardResult{}
+		sent := 0
+		for _, phone := range t.To {
+			result := forwardResult{Phone: phone}
+			if phone == "" {
+				result.Error = "Missing Phone"
+				results = append(results, result)
+				continue
+			}
+			recipient, ok := parseJID(phone)
+			if !ok {
+				result.Error = "Could not parse Phone"
+				results = append(results, result)
+				continue
+			}
+
+			msgid := whatsmeow.GenerateMessageID()
+			resp, err := clientPointer[userid].SendMessage(r.Context(), recipient, msg, sendRequestExtra(msg, msgid))
+			if err != nil {
+				log.Warn().Err(err).Str("id", t.MessageId).Str("to", recipient.String()).Msg("Could not forward message")
+				result.Error = fmt.Sprintf("Error sending message: %v", err)
+				results = append(results, result)
+				continue
+			}
+			storeSentMessage(s.db, userid, clientPointer[userid], recipient, msgid, msg, resp.Timestamp)
+			result.Id = msgid
+			result.Timestamp = &resp.Timestamp
+			results = append(results, result)
+			sent++
+		}
+
+		log.Info().Str("id", t.MessageId).Int("sent", sent).Int("targets", len(t.To)).Msg("Message forwarded")
+		response := map[string]interface{}{"Details": fmt.Sprintf("Forwarded to %d of %d chats", sent, len(t.To)), "Results": results}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
@@ -21,6 +21,7 @@ require (
 require (
 	github.com/lib/pq v1.10.9
 	github.com/rs/cors v1.11.1
+	golang.org/x/net v0.46.0
 )
 
 require (
@@ -45,7 +46,6 @@ require (
 	go.mau.fi/libsignal v0.2.1 // indirect
 	go.mau.fi/util v0.9.2 // indirect
 	golang.org/x/crypto v0.43.0 // indirect
-	golang.org/x/net v0.46.0 // indirect
 	golang.org/x/sys v0.37.0 // indirect
 	rsc.io/qr v0.2.0 // indirect
 )
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Group automations greet members who join a group and say goodbye to the
+// ones who leave. Rules are stored per instance and group in the
+// group_automations table, one for each event. The cooldown keeps a group
+// from being flooded when many members join at once, members joining
+// together are greeted in a single message.
+
+const (
+	automationWelcome  = "welcome"
+	automationFarewell = "farewell"
+)
+
+type groupAutomation struct {
+	GroupJID   string     `db:"group_jid"`
+	Event      string     `db:"event"` // welcome or farewell
+	Enabled    bool       `db:"enabled"`
+	Body       string     `db:"body"`  // text with {{name}} and {{group}}, used as caption when there is media
+	Image      string     `db:"image"` // optional media as data URL, only one of them
+	Video      string     `db:"video"`
+	Document   string     `db:"document"`
+	FileName   string     `db:"file_name"` // document
+	Mention    bool       `db:"mention"`   // mention the members, {{name}} renders as their @ tag
+	Cooldown   int        `db:"cooldown"`  // minimum seconds between two messages of the rule
+	Media      string     `db:"-"`         // image, video or document, filled in listings
+	LastSentAt *time.Time `db:"last_sent_at"`
+	UpdatedAt  time.Time  `db:"updated_at"`
+}
+
+const automationColumns = `group_jid, event, enabled, body, image, video, document, file_name, mention, cooldown, last_sent_at, updated_at`
+
+func (rule *groupAutomation) validate() error {
+	if rule.Event != automationWelcome && rule.Event != automationFarewell {
+		return errors.New("Invalid Event in Payload. Use: welcome or farewell")
+	}
+	if rule.Body == "" {
+		return errors.New("Missing Body in Payload")
+	}
+	if rule.Cooldown < 0 {
+		return errors.New("Cooldown cannot be negative")
+	}
+
+	media := 0
+	for _, value := range []string{rule.Image, rule.Video, rule.Document} {
+		if value == "" {
+			continue
+		}
+		if !strings.HasPrefix(value, "data:") {
+			return errors.New("Media data should start with \"data:mime/type;base64,\"")
+		}
+		media++
+	}
+	if media > 1 {
+		return errors.New("Only one of Image, Video or Document can be set")
+	}
+	if rule.Document != "" && rule.FileName == "" {
+		return errors.New("Missing FileName in Payload")
+	}
+	return nil
+}
+
+// Returns the rule without the media data, for listings
+func (rule groupAutomation) summary() groupAutomation {
+	switch {
+	case rule.Image != "":
+		rule.Media = "image"
+	case rule.Video != "":
+		rule.Media = "video"
+	case rule.Document != "":
+		rule.Media = "document"
+	}
+	rule.Image = ""
+	rule.Video = ""
+	rule.Document = ""
+	return rule
+}
+
+// Returns the content of the message of the rule with its variables replaced
+func (rule groupAutomation) content(vars map[string]string) messageContent {
+	text := renderVariables(rule.Body, vars)
+	c := messageContent{Image: rule.Image, Video: rule.Video, Document: rule.Document, FileName: rule.FileName, Caption: text}
+	switch {
+	case rule.Image != "":
+		c.Type = "image"
+	case rule.Video != "":
+		c.Type = "video"
+	case rule.Document != "":
+		c.Type = "document"
+	default:
+		c.Type = "text"
+		c.Body = text
+		c.Caption = ""
+	}
+	return c
+}
+
+// Rule claimed for sending, with the time it was sent before so the claim
+// can be undone when the message is not sent
+type claimedAutomation struct {
+	groupAutomation
+	PreviousSentAt *time.Time `db:"previous_sent_at"`
+}
+
+// Returns the enabled rule of a group event and marks it as sent, unless it
+// is still cooling down. Marking it in the same statement keeps two changes
+// arriving together from both sending it.
+func claimGroupAutomation(db *sqlx.DB, userID int, group types.JID, event string) (claimedAutomation, bool) {
+	var rule claimedAutomation
+	err := db.Get(&rule, `WITH previous AS (
+			SELECT last_sent_at FROM group_automations WHERE user_id=$1 AND group_jid=$2 AND event=$3
+		)
+		UPDATE group_automations SET last_sent_at=NOW()
+		WHERE user_id=$1 AND group_jid=$2 AND event=$3 AND enabled
+		AND (last_sent_at IS NULL OR last_sent_at + cooldown * INTERVAL '1 second' <= NOW())
+		RETURNING `+automationColumns+`, (SELECT last_sent_at FROM previous) AS previous_sent_at`, userID, group.ToNonAD().String(), event)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Str("group", group.String()).Str("event", event).Msg("Could not load group automation")
+		}
+		return rule, false
+	}
+	return rule, true
+}
+
+// Puts back the time a rule was sent before its claim, so a message that
+// could not be sent does not start the cooldown
+func releaseGroupAutomation(db *sqlx.DB, userID int, group types.JID, rule claimedAutomation) {
+	_, err := db.Exec(`UPDATE group_automations SET last_sent_at=$1
+		WHERE user_id=$2 AND group_jid=$3 AND event=$4 AND last_sent_at=$5`,
+		rule.PreviousSentAt, userID, group.ToNonAD().String(), rule.Event, rule.LastSentAt)
+	if err != nil {
+		log.Error().Err(err).Str("group", group.String()).Str("event", rule.Event).Msg("Could not release group automation")
+	}
+}
+
+// Returns how a member is called in automated messages, the contact name
+// when known or the phone number
+func memberName(ctx context.Context, client *whatsmeow.Client, jid types.JID) string {
+	if jid.Server == types.HiddenUserServer {
+		if pn, err := client.Store.LIDs.GetPNForLID(ctx, jid); err == nil && !pn.IsEmpty() {
+			jid = pn
+		}
+	}
+	if contact, err := client.Store.Contacts.GetContact(ctx, jid); err == nil && contact.Found {
+		for _, name := range []string{contact.FullName, contact.FirstName, contact.PushName, contact.BusinessName} {
+			if name != "" {
+				return name
+			}
+		}
+	}
+	if jid.Server == types.DefaultUserServer {
+		return "+" + jid.User
+	}
+	return jid.User
+}
+
+// Sends the welcome and farewell messages of a group change
+func (mycli *MyClient) runGroupAutomations(evt *events.GroupInfo) {
+	own := []types.JID{mycli.WAClient.Store.GetJID().ToNonAD(), mycli.WAClient.Store.GetLID().ToNonAD()}
+	members := func(jids []types.JID) []types.JID {
+		var list []types.JID
+	Members:
+		for _, jid := range jids {
+			for _, ownJID := range own {
+				if jid.ToNonAD() == ownJID {
+					continue Members
+				}
+			}
+			list = append(list, jid.ToNonAD())
+		}
+		return list
+	}
+
+	changes := []struct {
+		event   string
+		members []types.JID
+	}{{automationWelcome, members(evt.Join)}, {automationFarewell, members(evt.Leave)}}
+	if len(changes[0].members) == 0 && len(changes[1].members) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), groupCacheFetchTimeout)
+	group, err := getGroupInfo(ctx, mycli.userID, evt.JID, false)
+	cancel()
+	if err != nil {
+		log.Warn().Err(err).Str("group", evt.JID.String()).Msg("Could not get group info for group automation")
+		return
+	}
+	if !isGroupAdmin(group, own...) {
+		log.Debug().Str("group", evt.JID.String()).Msg("Skipping group automation, the instance is not admin of the group")
+		return
+	}
+
+	for _, change := range changes {
+		if len(change.members) == 0 {
+			continue
+		}
+		rule, ok := claimGroupAutomation(mycli.db, mycli.userID, evt.JID, change.event)
+		if !ok {
+			continue
+		}
+		if err := mycli.sendGroupAutomation(evt.JID, rule.groupAutomation, change.members); err != nil {
+			log.Error().Err(err).Str("group", evt.JID.String()).Str("event", change.event).Msg("Could not send group automation message")
+			releaseGroupAutomation(mycli.db, mycli.userID, evt.JID, rule)
+		}
+	}
+}
+
+func (mycli *MyClient) sendGroupAutomation(group types.JID, rule groupAutomation, members []types.JID) error {
+	client := mycli.WAClient
+	ctx, cancel := context.WithTimeout(context.Background(), queueSendTimeout)
+	defer cancel()
+
+	names := make([]string, len(members))
+	mentions := make([]string, len(members))
+	for i, jid := range members {
+		mentions[i] = jid.String()
+		if rule.Mention {
+			names[i] = "@" + jid.User
+		} else {
+			names[i] = memberName(ctx, client, jid)
+		}
+	}
+	vars := map[string]string{"name": strings.Join(names, ", ")}
+	if info, err := getGroupInfo(ctx, mycli.userID, group, false); err == nil {
+		vars["group"] = info.Name
+	}
+
+	msg, err := buildMessage(ctx, client, rule.content(vars))
+	if err != nil {
+		return err
+	}
+	if contextInfo := contextInfoOf(msg); contextInfo != nil {
+		if rule.Mention {
+			contextInfo.MentionedJID = mentions
+		}
+		if timer, ok := getEphemeralTimer(mycli.db, mycli.userID, group); ok && timer > 0 {
+			contextInfo.Expiration = proto.Uint32(timer)
+		}
+	}
+
+	msgid := whatsmeow.GenerateMessageID()
+	resp, err := client.SendMessage(ctx, group, msg, sendRequestExtra(msg, msgid))
+	if err != nil {
+		return err
+	}
+	log.Info().Str("id", msgid).Str("group", group.String()).Str("event", rule.Event).Int("members", len(members)).Msg("Group automation message sent")
+	storeSentMessage(mycli.db, mycli.userID, client, group, msgid, msg, resp.Timestamp)
+	return nil
+}
+
+// Lists the automation rules of a group, or of all groups when no group is
+// given
+func (s *server) ListGroupAutomations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		groupJID := r.URL.Query().Get("groupJID")
+		if groupJID != "" {
+			group, ok := parseJID(groupJID)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+				return
+			}
+			groupJID = group.ToNonAD().String()
+		}
+
+		rules := []groupAutomation{}
+		err := s.db.Select(&rules, `SELECT `+automationColumns+` FROM group_automations
+			WHERE user_id=$1 AND ($2 = '' OR group_jid=$2) ORDER BY group_jid, event`, userid, groupJID)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list group automations: %v", err)))
+			return
+		}
+		for i, rule := range rules {
+			rules[i] = rule.summary()
+		}
+
+		responseJson, err := json.Marshal(rules)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Creates or replaces the automation rule of a group event
+func (s *server) SetGroupAutomation() http.HandlerFunc {
+
+	type setGroupAutomationStruct struct {
+		groupAutomation
+		Enabled *bool // enabled when not given
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupAutomationStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		rule := t.groupAutomation
+		if rule.GroupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing GroupJID in Payload"))
+			return
+		}
+		group, ok := parseJID(rule.GroupJID)
+		if !ok || group.Server != types.GroupServer {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+			return
+		}
+		rule.GroupJID = group.ToNonAD().String()
+		rule.Enabled = t.Enabled == nil || *t.Enabled
+		if err := rule.validate(); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		err = s.db.Get(&rule, `INSERT INTO group_automations
+			(user_id, group_jid, event, enabled, body, image, video, document, file_name, mention, cooldown, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW())
+			ON CONFLICT (user_id, group_jid, event) DO UPDATE SET enabled=EXCLUDED.enabled, body=EXCLUDED.body,
+			image=EXCLUDED.image, video=EXCLUDED.video, document=EXCLUDED.document, file_name=EXCLUDED.file_name,
+			mention=EXCLUDED.mention, cooldown=EXCLUDED.cooldown, updated_at=EXCLUDED.updated_at
+			RETURNING `+automationColumns,
+			userid, rule.GroupJID, rule.Event, rule.Enabled, rule.Body, rule.Image, rule.Video, rule.Document, rule.FileName, rule.Mention, rule.Cooldown)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not save group automation: %v", err)))
+			return
+		}
+
+		log.Info().Str("group", rule.GroupJID).Str("event", rule.Event).Bool("enabled", rule.Enabled).Msg("Group automation saved")
+		responseJson, err := json.Marshal(rule.summary())
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Returns the handler that enables or disables the automation rules of a
+// group, both events unless one is given
+func (s *server) ChangeGroupAutomationState(enabled bool) http.HandlerFunc {
+
+	type groupAutomationStateStruct struct {
+		GroupJID string
+		Event    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		decoder := json.NewDecoder(r.Body)
+		var t groupAutomationStateStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.GroupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing GroupJID in Payload"))
+			return
+		}
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+			return
+		}
+		if t.Event != "" && t.Event != automationWelcome && t.Event != automationFarewell {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Invalid Event in Payload. Use: welcome or farewell"))
+			return
+		}
+
+		result, err := s.db.Exec(`UPDATE group_automations SET enabled=$1, updated_at=NOW()
+			WHERE user_id=$2 AND group_jid=$3 AND ($4 = '' OR event=$4)`, enabled, userid, group.ToNonAD().String(), t.Event)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not update group automation: %v", err)))
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Group automation not found"))
+			return
+		}
+
+		details := map[bool]string{true: "Group automation enabled", false: "Group automation disabled"}[enabled]
+		log.Info().Str("group", group.String()).Str("event", t.Event).Msg(details)
+		response := map[string]interface{}{"Details": details}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Deletes the automation rules of a group, both events unless one is given
+func (s *server) DeleteGroupAutomation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		groupJID := r.URL.Query().Get("groupJID")
+		if groupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing groupJID parameter"))
+			return
+		}
+		group, ok := parseJID(groupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+			return
+		}
+		event := r.URL.Query().Get("event")
+
+		result, err := s.db.Exec(`DELETE FROM group_automations WHERE user_id=$1 AND group_jid=$2 AND ($3 = '' OR event=$3)`,
+			userid, group.ToNonAD().String(), event)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not delete group automation: %v", err)))
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Group automation not found"))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group automation deleted"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
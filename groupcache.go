@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Metadata of the groups of every instance is cached when it is fetched and
+// kept up to date with the group changes WhatsApp notifies, so group
+// messages can carry the subject and roles without asking WhatsApp again.
+// Entries expire after a day in case a change was missed while offline.
+
+const (
+	groupCacheTTL          = 24 * time.Hour
+	groupCacheFetchTimeout = 10 * time.Second
+)
+
+var (
+	groupCache = cache.New(groupCacheTTL, time.Hour)
+	// Groups being fetched in the background, so a burst of messages of a
+	// group not cached yet fetches it only once
+	groupFetches sync.Map
+)
+
+type cachedGroup struct {
+	*types.GroupInfo
+	CachedAt time.Time
+}
+
+// Group context added to Message webhooks of group messages
+type groupContext struct {
+	JID             string   `json:"jid"`
+	Subject         string   `json:"subject"`
+	Size            int      `json:"size"`
+	IsAnnounce      bool     `json:"isAnnounce"`
+	LinkedParentJID string   `json:"linkedParentJid,omitempty"`
+	SenderRole      string   `json:"senderRole"` // superadmin, admin or member, empty when the sender is not a participant
+	Admins          []string `json:"admins"`
+}
+
+func groupCacheKey(userID int, group types.JID) string {
+	return fmt.Sprintf("%d:%s", userID, group.ToNonAD().String())
+}
+
+// Stores the metadata of a group
+func cacheGroupInfo(userID int, info *types.GroupInfo) *cachedGroup {
+	cached := &cachedGroup{GroupInfo: info, CachedAt: time.Now()}
+	groupCache.Set(groupCacheKey(userID, info.JID), cached, cache.DefaultExpiration)
+	return cached
+}
+
+// Returns the cached metadata of a group
+func cachedGroupInfo(userID int, group types.JID) (*cachedGroup, bool) {
+	if cached, found := groupCache.Get(groupCacheKey(userID, group)); found {
+		return cached.(*cachedGroup), true
+	}
+	return nil, false
+}
+
+// Returns the metadata of a group, from the cache unless it is missing or
+// refresh is set
+func getGroupInfo(ctx context.Context, userID int, group types.JID, refresh bool) (*cachedGroup, error) {
+	if !refresh {
+		if cached, ok := cachedGroupInfo(userID, group); ok {
+			return cached, nil
+		}
+	}
+	if clientPointer[userID] == nil {
+		return nil, errors.New("No session")
+	}
+	info, err := clientPointer[userID].GetGroupInfo(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	return cacheGroupInfo(userID, info), nil
+}
+
+// Fetches the metadata of a group in the background, unless it is already
+// being fetched
+func refreshGroupInfo(userID int, group types.JID) {
+	key := groupCacheKey(userID, group)
+	if _, fetching := groupFetches.LoadOrStore(key, struct{}{}); fetching {
+		return
+	}
+	go func() {
+		defer groupFetches.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), groupCacheFetchTimeout)
+		defer cancel()
+		if _, err := getGroupInfo(ctx, userID, group, true); err != nil {
+			log.Warn().Err(err).Str("group", group.String()).Msg("Could not fetch group info")
+		}
+	}()
+}
+
+// Caches the metadata of all groups of an instance, so the messages received
+// after connecting can be enriched without fetching their group first
+func prefetchGroups(userID int) {
+	client := clientPointer[userID]
+	if client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), groupCacheFetchTimeout)
+	defer cancel()
+	groups, err := client.GetJoinedGroups(ctx)
+	if err != nil {
+		log.Warn().Err(err).Int("userid", userID).Msg("Could not prefetch joined groups")
+		return
+	}
+	for _, info := range groups {
+		cacheGroupInfo(userID, info)
+	}
+	log.Info().Int("userid", userID).Int("groups", len(groups)).Msg("Cached joined groups")
+}
+
+// Drops the cached groups of an instance
+func forgetCachedGroups(userID int) {
+	prefix := strconv.Itoa(userID) + ":"
+	for key := range groupCache.Items() {
+		if strings.HasPrefix(key, prefix) {
+			groupCache.Delete(key)
+		}
+	}
+}
+
+func participantIs(participant types.GroupParticipant, jid types.JID) bool {
+	jid = jid.ToNonAD()
+	return jid == participant.JID || jid == participant.LID || jid == participant.PhoneNumber
+}
+
+// Reports whether any of the given JIDs is admin or superadmin of a group
+func isGroupAdmin(group *cachedGroup, jids ...types.JID) bool {
+	for _, participant := range group.Participants {
+		if !participant.IsAdmin && !participant.IsSuperAdmin {
+			continue
+		}
+		for _, jid := range jids {
+			if !jid.IsEmpty() && participantIs(participant, jid) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Applies a group change to the cached metadata. Groups not cached are left
+// to be fetched when needed. The cached value is copied, not changed, since
+// it may be in use by a request.
+func updateCachedGroup(userID int, ownJIDs []types.JID, evt *events.GroupInfo) {
+	cached, ok := cachedGroupInfo(userID, evt.JID)
+	if !ok {
+		return
+	}
+	if evt.Delete != nil {
+		groupCache.Delete(groupCacheKey(userID, evt.JID))
+		return
+	}
+
+	info := *cached.GroupInfo
+	info.Participants = append([]types.GroupParticipant(nil), cached.Participants...)
+	if evt.Name != nil {
+		info.GroupName = *evt.Name
+	}
+	if evt.Topic != nil {
+		info.GroupTopic = *evt.Topic
+	}
+	if evt.Locked != nil {
+		info.GroupLocked = *evt.Locked
+	}
+	if evt.Announce != nil {
+		info.GroupAnnounce = *evt.Announce
+	}
+	if evt.Ephemeral != nil {
+		info.GroupEphemeral = *evt.Ephemeral
+	}
+	if evt.MembershipApprovalMode != nil {
+		info.GroupMembershipApprovalMode = *evt.MembershipApprovalMode
+	}
+	if evt.ParticipantVersionID != "" {
+		info.ParticipantVersionID = evt.ParticipantVersionID
+	}
+
+	for _, jid := range evt.Leave {
+		for _, own := range ownJIDs {
+			if jid.ToNonAD() == own.ToNonAD() {
+				// The instance left or was removed
+				groupCache.Delete(groupCacheKey(userID, evt.JID))
+				return
+			}
+		}
+		kept := info.Participants[:0]
+		for _, participant := range info.Participants {
+			if !participantIs(participant, jid) {
+				kept = append(kept, participant)
+			}
+		}
+		info.Participants = kept
+	}
+Join:
+	for _, jid := range evt.Join {
+		for _, participant := range info.Participants {
+			if participantIs(participant, jid) {
+				continue Join
+			}
+		}
+		participant := types.GroupParticipant{JID: jid.ToNonAD()}
+		if jid.Server == types.HiddenUserServer {
+			participant.LID = participant.JID
+		} else {
+			participant.PhoneNumber = participant.JID
+		}
+		info.Participants = append(info.Participants, participant)
+	}
+	for i, participant := range info.Participants {
+		for _, jid := range evt.Promote {
+			if participantIs(participant, jid) {
+				info.Participants[i].IsAdmin = true
+			}
+		}
+		for _, jid := range evt.Demote {
+			if participantIs(participant, jid) {
+				info.Participants[i].IsAdmin = false
+				info.Participants[i].IsSuperAdmin = false
+			}
+		}
+	}
+
+	cacheGroupInfo(userID, &info)
+}
+
+// Builds the group context of a group message from the cache. It runs in the
+// event handler, so a group not cached is fetched in the background and the
+// message goes without context.
+func (mycli *MyClient) groupContextOf(evt *events.Message) *groupContext {
+	group, ok := cachedGroupInfo(mycli.userID, evt.Info.Chat)
+	if !ok {
+		refreshGroupInfo(mycli.userID, evt.Info.Chat)
+		return nil
+	}
+
+	gc := &groupContext{
+		JID:        group.JID.String(),
+		Subject:    group.Name,
+		Size:       len(group.Participants),
+		IsAnnounce: group.IsAnnounce,
+		Admins:     []string{},
+	}
+	if !group.LinkedParentJID.IsEmpty() {
+		gc.LinkedParentJID = group.LinkedParentJID.String()
+	}
+	for _, participant := range group.Participants {
+		if participant.IsAdmin || participant.IsSuperAdmin {
+			gc.Admins = append(gc.Admins, participant.JID.String())
+		}
+		if participantIs(participant, evt.Info.Sender) || (!evt.Info.SenderAlt.IsEmpty() && participantIs(participant, evt.Info.SenderAlt)) {
+			switch {
+			case participant.IsSuperAdmin:
+				gc.SenderRole = "superadmin"
+			case participant.IsAdmin:
+				gc.SenderRole = "admin"
+			default:
+				gc.SenderRole = "member"
+			}
+		}
+	}
+	return gc
+}
+
+// Returns the cached metadata of a group, or of all cached groups of the
+// instance when no group is given
+func (s *server) GetCachedGroups() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		refresh := r.URL.Query().Get("refresh") == "true"
+		groupJID := r.URL.Query().Get("groupJID")
+
+		var response interface{}
+		if groupJID == "" {
+			if refresh {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Missing groupJID parameter, refresh is only supported for a single group"))
+				return
+			}
+			prefix := txtid + ":"
+			groups := []*cachedGroup{}
+			for key, item := range groupCache.Items() {
+				if strings.HasPrefix(key, prefix) {
+					groups = append(groups, item.Object.(*cachedGroup))
+				}
+			}
+			response = map[string]interface{}{"Groups": groups}
+		} else {
+			group, ok := parseJID(groupJID)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+				return
+			}
+			cached, err := getGroupInfo(r.Context(), userid, group, refresh)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to get group info: %v", err)))
+				return
+			}
+			response = cached
+		}
+
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
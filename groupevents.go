@@ -0,0 +1,119 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// A single GroupInfo event can carry several changes. Besides the raw
+// event, every change is posted as its own webhook with only the fields
+// that matter for it, so they can be subscribed to separately.
+
+// Fields shared by all group change webhooks
+type groupChange struct {
+	GroupJID  string
+	Actor     string `json:",omitempty"` // who made the change, not sent for some changes like joining by link
+	ActorPN   string `json:",omitempty"` // phone number of the actor when Actor is a LID
+	Timestamp time.Time
+}
+
+// Posted with the GroupParticipantsUpdate webhook
+type groupParticipantsUpdate struct {
+	groupChange
+	Action       string // join, leave, promote or demote
+	Participants []string
+	JoinReason   string `json:",omitempty"` // invite when joining by invite link
+}
+
+// Posted with the GroupSubjectChange webhook
+type groupSubjectChange struct {
+	groupChange
+	Subject string
+}
+
+// Posted with the GroupDescriptionChange webhook
+type groupDescriptionChange struct {
+	groupChange
+	Description string
+	Deleted     bool
+}
+
+// Posted with the GroupSettingsChange webhook
+type groupSettingsChange struct {
+	groupChange
+	Setting           string // announce, locked, ephemeral or approval
+	Enabled           bool
+	DisappearingTimer uint32 `json:",omitempty"` // ephemeral, in seconds
+}
+
+func jidStrings(jids []types.JID) []string {
+	list := make([]string, len(jids))
+	for i, jid := range jids {
+		list[i] = jid.String()
+	}
+	return list
+}
+
+// Splits a group change into the webhooks of every change it carries
+func groupChangeEvents(evt *events.GroupInfo) map[string][]interface{} {
+	source := groupChange{GroupJID: evt.JID.String(), Timestamp: evt.Timestamp}
+	if evt.Sender != nil {
+		source.Actor = evt.Sender.String()
+	}
+	if evt.SenderPN != nil {
+		source.ActorPN = evt.SenderPN.String()
+	}
+
+	changes := map[string][]interface{}{}
+	add := func(eventType string, event interface{}) {
+		changes[eventType] = append(changes[eventType], event)
+	}
+
+	for _, participants := range []struct {
+		action string
+		jids   []types.JID
+	}{{"join", evt.Join}, {"leave", evt.Leave}, {"promote", evt.Promote}, {"demote", evt.Demote}} {
+		if len(participants.jids) == 0 {
+			continue
+		}
+		update := groupParticipantsUpdate{groupChange: source, Action: participants.action, Participants: jidStrings(participants.jids)}
+		if participants.action == "join" {
+			update.JoinReason = evt.JoinReason
+		}
+		add("GroupParticipantsUpdate", update)
+	}
+	if evt.Name != nil {
+		add("GroupSubjectChange", groupSubjectChange{groupChange: source, Subject: evt.Name.Name})
+	}
+	if evt.Topic != nil {
+		add("GroupDescriptionChange", groupDescriptionChange{groupChange: source, Description: evt.Topic.Topic, Deleted: evt.Topic.TopicDeleted})
+	}
+	if evt.Announce != nil {
+		add("GroupSettingsChange", groupSettingsChange{groupChange: source, Setting: "announce", Enabled: evt.Announce.IsAnnounce})
+	}
+	if evt.Locked != nil {
+		add("GroupSettingsChange", groupSettingsChange{groupChange: source, Setting: "locked", Enabled: evt.Locked.IsLocked})
+	}
+	if evt.Ephemeral != nil {
+		add("GroupSettingsChange", groupSettingsChange{groupChange: source, Setting: "ephemeral", Enabled: evt.Ephemeral.IsEphemeral, DisappearingTimer: evt.Ephemeral.DisappearingTimer})
+	}
+	if evt.MembershipApprovalMode != nil {
+		add("GroupSettingsChange", groupSettingsChange{groupChange: source, Setting: "approval", Enabled: evt.MembershipApprovalMode.IsJoinApprovalRequired})
+	}
+	return changes
+}
+
+// Posts the webhooks of the changes of a group
+func (mycli *MyClient) sendGroupChanges(evt *events.GroupInfo) {
+	changes := groupChangeEvents(evt)
+	// Fixed order, so the order of the webhooks does not depend on the map
+	for _, eventType := range []string{"GroupParticipantsUpdate", "GroupSubjectChange", "GroupDescriptionChange", "GroupSettingsChange"} {
+		for _, event := range changes[eventType] {
+			log.Debug().Str("group", evt.JID.String()).Str("type", eventType).Msg("Group change")
+			sendEventWebhook(mycli.userID, mycli.token, eventType, event)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Groups with membership approval keep whoever asks to join, by invite link
+// or from the community, waiting until an admin approves or rejects them.
+
+// Posted with the GroupJoinRequest webhook
+type groupJoinRequest struct {
+	GroupJID  string
+	JID       string // who asked to join
+	Method    string // invite_link, linked_group_join or non_admin_add
+	Timestamp time.Time
+}
+
+// Returns the join requests announced by a group change. whatsmeow does not
+// parse them, so they come as unknown changes.
+func groupJoinRequestsOf(evt *events.GroupInfo) []groupJoinRequest {
+	var requests []groupJoinRequest
+	for _, node := range evt.UnknownChanges {
+		if node.Tag != "created_membership_requests" {
+			continue
+		}
+		method := node.AttrGetter().OptionalString("request_method")
+
+		var requesters []types.JID
+		for _, child := range node.GetChildren() {
+			if jid := child.AttrGetter().OptionalJID("jid"); jid != nil {
+				requesters = append(requesters, *jid)
+			}
+		}
+		if len(requesters) == 0 && evt.Sender != nil {
+			requesters = append(requesters, *evt.Sender)
+		}
+
+		for _, jid := range requesters {
+			requests = append(requests, groupJoinRequest{
+				GroupJID:  evt.JID.String(),
+				JID:       jid.String(),
+				Method:    method,
+				Timestamp: evt.Timestamp,
+			})
+		}
+	}
+	return requests
+}
+
+// Lists the pending join requests of a group
+func (s *server) GetGroupRequests() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		groupJID := r.URL.Query().Get("groupJID")
+		if groupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing groupJID parameter"))
+			return
+		}
+		group, ok := parseJID(groupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+			return
+		}
+
+		requests, err := clientPointer[userid].GetGroupRequestParticipants(r.Context(), group)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get group join requests: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+			return
+		}
+		if requests == nil {
+			requests = []types.GroupParticipantRequest{}
+		}
+
+		response := map[string]interface{}{"GroupJID": group.String(), "Requests": requests}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Approves or rejects join requests of a group
+func (s *server) UpdateGroupRequests() http.HandlerFunc {
+
+	type updateGroupRequestsStruct struct {
+		GroupJID     string
+		Participants []string
+		Action       string // approve or reject
+	}
+
+	type requestResult struct {
+		JID   string
+		Error int `json:",omitempty"` // error code returned by WhatsApp for this participant
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t updateGroupRequestsStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.GroupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing GroupJID in Payload"))
+			return
+		}
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+			return
+		}
+
+		if len(t.Participants) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Participants in Payload"))
+			return
+		}
+		participants := make([]types.JID, len(t.Participants))
+		for i, phone := range t.Participants {
+			if phone == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Participant"))
+				return
+			}
+			participants[i], ok = parseJID(phone)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("Could not parse Participant %q", phone)))
+				return
+			}
+		}
+
+		var action whatsmeow.ParticipantRequestChange
+		switch t.Action {
+		case "approve":
+			action = whatsmeow.ParticipantChangeApprove
+		case "reject":
+			action = whatsmeow.ParticipantChangeReject
+		case "":
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Action in Payload"))
+			return
+		default:
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Invalid Action in Payload. Use: approve or reject"))
+			return
+		}
+
+		changed, err := clientPointer[userid].UpdateGroupRequestParticipants(r.Context(), group, participants, action)
+		if err != nil {
+			log.Error().Err(err).Str("group", group.String()).Msg("Failed to update group join requests")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to update group join requests: %v", err)))
+			return
+		}
+
+		results := make([]requestResult, len(changed))
+		failed := 0
+		for i, participant := range changed {
+			results[i] = requestResult{JID: participant.JID.String(), Error: participant.Error}
+			if participant.Error != 0 {
+				failed++
+			}
+		}
+
+		log.Info().Str("group", group.String()).Str("action", t.Action).Int("participants", len(results)).Int("failed", failed).Msg("Group join requests updated")
+		response := map[string]interface{}{
+			"Details":      fmt.Sprintf("%d of %d join requests updated", len(results)-failed, len(results)),
+			"Participants": results,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Turns admin approval of new members of a group on or off
+func (s *server) SetGroupApproval() http.HandlerFunc {
+
+	type setGroupApprovalStruct struct {
+		GroupJID string
+		Approval bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupApprovalStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.GroupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing GroupJID in Payload"))
+			return
+		}
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+			return
+		}
+
+		err = clientPointer[userid].SetGroupJoinApprovalMode(r.Context(), group, t.Approval)
+		if err != nil {
+			log.Error().Err(err).Str("group", group.String()).Msg("Failed to set group approval mode")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to set group approval mode: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Details": map[bool]string{true: "Membership approval enabled", false: "Membership approval disabled"}[t.Approval]}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
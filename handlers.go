@@ -1,14 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"image"
-	"image/jpeg"
 	"net/http"
 	"net/url"
 	"os"
@@ -18,7 +15,6 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/nfnt/resize"
 	"github.com/patrickmn/go-cache"
 	"github.com/rs/zerolog/log"
 	"github.com/vincent-petithory/dataurl"
@@ -687,1025 +683,29 @@ func (s *server) GetStatus() http.HandlerFunc {
 
 // Sends a document/attachment message
 func (s *server) SendDocument() http.HandlerFunc {
-
-	type documentStruct struct {
-		Caption     string
-		Phone       string
-		Document    string
-		FileName    string
-		Id          string
-		MimeType    string
-		ContextInfo waProto.ContextInfo
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t documentStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
-			return
-		}
-
-		if t.Document == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Document in Payload"))
-			return
-		}
-
-		if t.FileName == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing FileName in Payload"))
-			return
-		}
-
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-
-		if strings.HasPrefix(t.Document, "data:") {
-			dataURL, err := dataurl.DecodeString(t.Document)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(r.Context(), filedata, whatsmeow.MediaDocument)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to upload file: %v", err)))
-					return
-				}
-				// Use MIME type from data URL if not provided in payload
-				if t.MimeType == "" && dataURL.MediaType.ContentType() != "" {
-					t.MimeType = dataURL.MediaType.ContentType()
-				}
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Document data should be a valid data URI (e.g., \"data:application/pdf;base64,...\" or \"data:application/vnd.openxmlformats-officedocument.wordprocessingml.document;base64,...\")"))
-			return
-		}
-
-		// Determine final MIME type
-		finalMimeType := func() string {
-			if t.MimeType != "" {
-				return t.MimeType
-			}
-			return http.DetectContentType(filedata)
-		}()
-
-		msg := &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
-			URL:           proto.String(uploaded.URL),
-			FileName:      &t.FileName,
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(finalMimeType),
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-			Caption:       proto.String(t.Caption),
-		}}
-
-		if t.ContextInfo.StanzaID != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
-		}
-		if t.ContextInfo.MentionedJID != nil {
-			if msg.ExtendedTextMessage.ContextInfo == nil {
-				msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{}
-			}
-			msg.ExtendedTextMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
-		}
-
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
-			return
-		}
-
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
-		responseJson, err := json.Marshal(response)
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-		return
-	}
-}
-
-// Sends an audio message
-func (s *server) SendAudio() http.HandlerFunc {
-
-	type audioStruct struct {
-		Phone       string
-		Audio       string
-		Caption     string
-		Id          string
-		ContextInfo waProto.ContextInfo
-		Seconds     uint32
-		Waveform    []byte
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t audioStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
-			return
-		}
-
-		if t.Audio == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Audio in Payload"))
-			return
-		}
-
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-
-		if t.Audio[0:14] == "data:audio/ogg" {
-			dataURL, err := dataurl.DecodeString(t.Audio)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(r.Context(), filedata, whatsmeow.MediaAudio)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to upload file: %v", err)))
-					return
-				}
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Audio data should start with \"data:audio/ogg;base64,\""))
-			return
-		}
-
-		ptt := true
-		mime := "audio/ogg; codecs=opus"
-
-		msg := &waProto.Message{AudioMessage: &waProto.AudioMessage{
-			URL:        proto.String(uploaded.URL),
-			DirectPath: proto.String(uploaded.DirectPath),
-			MediaKey:   uploaded.MediaKey,
-			//Mimetype:      proto.String(http.DetectContentType(filedata)),
-			Mimetype:      &mime,
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-			PTT:           &ptt,
-			Seconds:       proto.Uint32(t.Seconds),
-			Waveform:      t.Waveform,
-		}}
-
-		if t.ContextInfo.StanzaID != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
-		}
-		if t.ContextInfo.MentionedJID != nil {
-			if msg.ExtendedTextMessage.ContextInfo == nil {
-				msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{}
-			}
-			msg.ExtendedTextMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
-		}
-
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
-			return
-		}
-
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
-		responseJson, err := json.Marshal(response)
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-		return
-	}
-}
-
-// Sends an Image message
-func (s *server) SendImage() http.HandlerFunc {
-
-	type imageStruct struct {
-		Phone       string
-		Image       string
-		Caption     string
-		Id          string
-		ContextInfo waProto.ContextInfo
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t imageStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
-			return
-		}
-
-		if t.Image == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Image in Payload"))
-			return
-		}
-
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-		var thumbnailBytes []byte
-
-		if t.Image[0:10] == "data:image" {
-			dataURL, err := dataurl.DecodeString(t.Image)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(r.Context(), filedata, whatsmeow.MediaImage)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to upload file: %v", err)))
-					return
-				}
-			}
-
-			// decode jpeg into image.Image
-			reader := bytes.NewReader(filedata)
-			img, _, err := image.Decode(reader)
-			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not decode image for thumbnail preparation: %v", err)))
-				return
-			}
-
-			// resize to width 72 using Lanczos resampling and preserve aspect ratio
-			m := resize.Thumbnail(72, 72, img, resize.Lanczos3)
-
-			tmpFile, err := os.CreateTemp("", "resized-*.jpg")
-			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not create temp file for thumbnail: %v", err)))
-				return
-			}
-			defer tmpFile.Close()
-
-			// write new image to file
-			if err := jpeg.Encode(tmpFile, m, nil); err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to encode jpeg: %v", err)))
-				return
-			}
-
-			thumbnailBytes, err = os.ReadFile(tmpFile.Name())
-			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to read %s: %v", tmpFile.Name(), err)))
-				return
-			}
-
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Image data should start with \"data:image/png;base64,\""))
-			return
-		}
-
-		msg := &waProto.Message{ImageMessage: &waProto.ImageMessage{
-			Caption:       proto.String(t.Caption),
-			URL:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-			JPEGThumbnail: thumbnailBytes,
-		}}
-
-		if t.ContextInfo.StanzaID != nil {
-			if msg.ImageMessage.ContextInfo == nil {
-				msg.ImageMessage.ContextInfo = &waProto.ContextInfo{
-					StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-					Participant:   proto.String(*t.ContextInfo.Participant),
-					QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-				}
-			}
-		}
-
-		if t.ContextInfo.MentionedJID != nil {
-			msg.ImageMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
-		}
-
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
-			return
-		}
-
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
-		responseJson, err := json.Marshal(response)
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-		return
-	}
-}
-
-// Send Pool
-func (s *server) SendPoll() http.HandlerFunc {
-	type pollRequest struct {
-		Group   string   `json:"group"`   // The recipient's group id (120363313346913103@g.us)
-		Header  string   `json:"header"`  // The poll's headline text
-		Options []string `json:"options"` // The list of poll options
-		Id      string
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
-		decoder := json.NewDecoder(r.Body)
-		var req pollRequest
-		err := decoder.Decode(&req)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode payload"))
-			return
-		}
-
-		if req.Group == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Grouop in payload"))
-			return
-		}
-
-		if req.Header == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Header in payload"))
-			return
-		}
-
-		if len(req.Options) < 2 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("At least 2 options are required"))
-			return
-		}
-
-		if req.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = req.Id
-		}
-
-		recipient, err := validateMessageFields(req.Group, nil, nil)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		pollMessage := clientPointer[userid].BuildPollCreation(req.Header, req.Options, 1)
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, pollMessage, whatsmeow.SendRequestExtra{ID: msgid})
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to send poll: %v", err)))
-			return
-		}
-
-		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Poll sent")
-
-		response := map[string]interface{}{"Details": "Poll sent successfully", "Id": msgid}
-		responseJson, err := json.Marshal(response)
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-	}
-}
-
-// Join group invite link
-func (s *server) GroupJoin() http.HandlerFunc {
-
-	type joinGroupStruct struct {
-		Code string
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t joinGroupStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		if t.Code == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Code in Payload"))
-			return
-		}
-
-		_, err = clientPointer[userid].JoinGroupWithLink(r.Context(), t.Code)
-
-		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to join group")
-			msg := fmt.Sprintf("Failed to join group: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
-			return
-		}
-
-		response := map[string]interface{}{"Details": "Group joined successfully"}
-		responseJson, err := json.Marshal(response)
-
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-
-		return
-	}
-}
-
-// Set group topic (description)
-func (s *server) SetGroupTopic() http.HandlerFunc {
-
-	type setGroupTopicStruct struct {
-		GroupJID string
-		Topic    string
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t setGroupTopicStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
-			return
-		}
-
-		if t.Topic == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Topic in Payload"))
-			return
-		}
-
-		err = clientPointer[userid].SetGroupTopic(r.Context(), group, "", "", t.Topic)
-
-		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group topic")
-			msg := fmt.Sprintf("Failed to set group topic: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
-			return
-		}
-
-		response := map[string]interface{}{"Details": "Group Topic set successfully"}
-		responseJson, err := json.Marshal(response)
-
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-
-		return
-	}
-}
-
-func (s *server) GroupLeave() http.HandlerFunc {
-
-	type groupLeaveStruct struct {
-		GroupJID string
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t groupLeaveStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
-			return
-		}
-
-		err = clientPointer[userid].LeaveGroup(r.Context(), group)
-
-		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to leave group")
-			msg := fmt.Sprintf("Failed to leave group: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
-			return
-		}
-
-		response := map[string]interface{}{"Details": "Group left successfully"}
-		responseJson, err := json.Marshal(response)
-
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-
-		return
-	}
-}
-
-// SetGroupAnnounce post
-func (s *server) SetGroupAnnounce() http.HandlerFunc {
-
-	type setGroupAnnounceStruct struct {
-		GroupJID string
-		Announce bool
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t setGroupAnnounceStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
-			return
-		}
-
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
-			return
-		}
-
-		// Verify user is admin of the group
-		groupInfo, err := clientPointer[userid].GetGroupInfo(r.Context(), group)
-		if err != nil {
-			log.Error().
-				Str("error", fmt.Sprintf("%v", err)).
-				Str("group", group.String()).
-				Msg("failed to get group info")
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to get group info"))
-			return
-		}
-
-		// Check if user is admin
-		userJID := clientPointer[userid].Store.ID
-		isAdmin := false
-
-		// Normalize user JID by removing device suffix (e.g., :53)
-		userJIDStr := userJID.String()
-		if idx := strings.Index(userJIDStr, ":"); idx != -1 {
-			userJIDStr = userJIDStr[:idx] + "@" + strings.Split(userJIDStr, "@")[1]
-		}
-
-		log.Info().
-			Str("user_jid", userJID.String()).
-			Str("user_jid_normalized", userJIDStr).
-			Str("group", group.String()).
-			Int("participants_count", len(groupInfo.Participants)).
-			Msg("checking admin status")
-
-		for i, participant := range groupInfo.Participants {
-			participantJIDStr := participant.JID.String()
-			jidsMatch := participantJIDStr == userJIDStr
-
-			log.Info().
-				Int("participant_index", i).
-				Str("participant_jid", participantJIDStr).
-				Bool("is_admin", participant.IsAdmin).
-				Str("user_jid_normalized", userJIDStr).
-				Bool("jids_match", jidsMatch).
-				Msg("checking participant")
-
-			if jidsMatch && participant.IsAdmin {
-				isAdmin = true
-				break
-			}
-		}
-
-		if !isAdmin {
-			log.Warn().
-				Str("group", group.String()).
-				Str("user", userJID.String()).
-				Msg("user is not admin of the group")
-			s.Respond(w, r, http.StatusForbidden, errors.New("only group admins can change announcement settings"))
-			return
-		}
-
-		log.Info().
-			Str("group", group.String()).
-			Bool("announce", t.Announce).
-			Str("user", userJID.String()).
-			Msg("attempting to set group announce")
-
-		err = clientPointer[userid].SetGroupAnnounce(r.Context(), group, t.Announce)
-
-		if err != nil {
-			log.Error().
-				Str("error", fmt.Sprintf("%v", err)).
-				Str("group", group.String()).
-				Bool("announce", t.Announce).
-				Str("user", userJID.String()).
-				Msg("failed to set group announce")
-			s.Respond(w, r, http.StatusInternalServerError, err)
-			return
-		}
-
-		// Verify the change was applied by getting group info again
-		updatedGroupInfo, err := clientPointer[userid].GetGroupInfo(r.Context(), group)
-		if err != nil {
-			log.Warn().
-				Str("error", fmt.Sprintf("%v", err)).
-				Msg("failed to verify group announce change")
-		} else {
-			log.Info().
-				Str("group", group.String()).
-				Bool("requested_announce", t.Announce).
-				Bool("actual_announce", updatedGroupInfo.IsAnnounce).
-				Str("user", userJID.String()).
-				Msg("group announce status after change")
-		}
-
-		log.Info().
-			Str("group", group.String()).
-			Bool("announce", t.Announce).
-			Str("user", userJID.String()).
-			Msg("successfully set group announce")
-
-		response := map[string]interface{}{"Details": "Group Announce set successfully"}
-		responseJson, err := json.Marshal(response)
-
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-
-		return
-	}
-}
-
-// add, remove, promote and demote members group
-func (s *server) UpdateGroupParticipants() http.HandlerFunc {
-
-	type updateGroupParticipantsStruct struct {
-		GroupJID     string
-		Participants []string
-		// Action string // add, remove, promote, demote
-		Action string
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t updateGroupParticipantsStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
-			return
-		}
-
-		if len(t.Participants) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
-			return
-		}
-		// parse phone numbers
-		phoneParsed := make([]types.JID, len(t.Participants))
-		for i, phone := range t.Participants {
-			phoneParsed[i], ok = parseJID(phone)
-			if !ok {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Phone"))
-				return
-			}
-		}
-
-		if t.Action == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Action in Payload"))
-			return
-		}
-
-		// parse action
-
-		var action whatsmeow.ParticipantChange
-		switch t.Action {
-		case "add":
-			action = "add"
-		case "remove":
-			action = "remove"
-		case "promote":
-			action = "promote"
-		case "demote":
-			action = "demote"
-		default:
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Invalid Action in Payload"))
-			return
-		}
-
-		_, err = clientPointer[userid].UpdateGroupParticipants(r.Context(), group, phoneParsed, action)
-
-		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to change participant group")
-			s.Respond(w, r, http.StatusInternalServerError, err)
-			return
-		}
-
-		response := map[string]interface{}{"Details": "Group Participants updated successfully"}
-		responseJson, err := json.Marshal(response)
-
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-
-		return
-	}
-}
-
-// Get group invite info
-func (s *server) GetGroupInviteInfo() http.HandlerFunc {
-
-	type getGroupInviteInfoStruct struct {
-		Code string
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t getGroupInviteInfoStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		if t.Code == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Code in Payload"))
-			return
-		}
-
-		groupInfo, err := clientPointer[userid].GetGroupInfoFromLink(r.Context(), t.Code)
-
-		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group invite info")
-			msg := fmt.Sprintf("Failed to get group invite info: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
-			return
-		}
-
-		responseJson, err := json.Marshal(groupInfo)
-
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-
-		return
-	}
+	return s.sendAs("document")
 }
 
-// Create group
-func (s *server) CreateGroup() http.HandlerFunc {
-
-	type createGroupStruct struct {
-		Name         string   `json:"name"`
-		Participants []string `json:"participants"`
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-
-		userid, _ := strconv.Atoi(txtid)
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t createGroupStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Name in Payload"))
-			return
-		}
-
-		if len(t.Participants) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Participants in Payload"))
-			return
-		}
-
-		// Parse participant phone numbers
-		participantJIDs := make([]types.JID, len(t.Participants))
-		var ok bool
-		for i, phone := range t.Participants {
-			participantJIDs[i], ok = parseJID(phone)
-			if !ok {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Participant Phone"))
-				return
-			}
-		}
-
-		req := whatsmeow.ReqCreateGroup{
-			Name:         t.Name,
-			Participants: participantJIDs,
-		}
-
-		groupInfo, err := clientPointer[userid].CreateGroup(r.Context(), req)
-
-		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to create group")
-			msg := fmt.Sprintf("Failed to create group: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
-			return
-		}
-
-		responseJson, err := json.Marshal(groupInfo)
+// Sends an audio message
+func (s *server) SendAudio() http.HandlerFunc {
+	return s.sendAs("audio")
+}
 
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
+// Sends an Image message
+func (s *server) SendImage() http.HandlerFunc {
+	return s.sendAs("image")
+}
 
-		return
-	}
+// Send Pool
+func (s *server) SendPoll() http.HandlerFunc {
+	return s.sendAs("poll")
 }
 
-// Set group locked
-func (s *server) SetGroupLocked() http.HandlerFunc {
+// Join group invite link
+func (s *server) GroupJoin() http.HandlerFunc {
 
-	type setGroupLockedStruct struct {
-		GroupJID string `json:"groupjid"`
-		Locked   bool   `json:"locked"`
+	type joinGroupStruct struct {
+		Code string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1720,29 +720,28 @@ func (s *server) SetGroupLocked() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t setGroupLockedStruct
+		var t joinGroupStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
 			return
 		}
 
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+		if t.Code == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Code in Payload"))
 			return
 		}
 
-		err = clientPointer[userid].SetGroupLocked(r.Context(), group, t.Locked)
+		_, err = clientPointer[userid].JoinGroupWithLink(r.Context(), t.Code)
 
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group locked")
-			msg := fmt.Sprintf("Failed to set group locked: %v", err)
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to join group")
+			msg := fmt.Sprintf("Failed to join group: %v", err)
 			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		response := map[string]interface{}{"Details": "Group Locked setting updated successfully"}
+		response := map[string]interface{}{"Details": "Group joined successfully"}
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
@@ -1755,12 +754,12 @@ func (s *server) SetGroupLocked() http.HandlerFunc {
 	}
 }
 
-// Set disappearing timer (ephemeral messages)
-func (s *server) SetDisappearingTimer() http.HandlerFunc {
+// Set group topic (description)
+func (s *server) SetGroupTopic() http.HandlerFunc {
 
-	type setDisappearingTimerStruct struct {
-		GroupJID string `json:"groupjid"`
-		Duration string `json:"duration"` // "24h", "7d", "90d", "off"
+	type setGroupTopicStruct struct {
+		GroupJID string
+		Topic    string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1775,7 +774,7 @@ func (s *server) SetDisappearingTimer() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t setDisappearingTimerStruct
+		var t setGroupTopicStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
@@ -1788,36 +787,21 @@ func (s *server) SetDisappearingTimer() http.HandlerFunc {
 			return
 		}
 
-		if t.Duration == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Duration in Payload"))
-			return
-		}
-
-		var duration time.Duration
-		switch t.Duration {
-		case "24h":
-			duration = 24 * time.Hour
-		case "7d":
-			duration = 7 * 24 * time.Hour
-		case "90d":
-			duration = 90 * 24 * time.Hour
-		case "off":
-			duration = 0
-		default:
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Invalid duration. Use: 24h, 7d, 90d, or off"))
+		if t.Topic == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Topic in Payload"))
 			return
 		}
 
-		err = clientPointer[userid].SetDisappearingTimer(r.Context(), group, duration, time.Now())
+		err = clientPointer[userid].SetGroupTopic(r.Context(), group, "", "", t.Topic)
 
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set disappearing timer")
-			msg := fmt.Sprintf("Failed to set disappearing timer: %v", err)
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group topic")
+			msg := fmt.Sprintf("Failed to set group topic: %v", err)
 			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		response := map[string]interface{}{"Details": "Disappearing timer set successfully"}
+		response := map[string]interface{}{"Details": "Group Topic set successfully"}
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
@@ -1830,11 +814,10 @@ func (s *server) SetDisappearingTimer() http.HandlerFunc {
 	}
 }
 
-// Remove group photo
-func (s *server) RemoveGroupPhoto() http.HandlerFunc {
+func (s *server) GroupLeave() http.HandlerFunc {
 
-	type removeGroupPhotoStruct struct {
-		GroupJID string `json:"groupjid"`
+	type groupLeaveStruct struct {
+		GroupJID string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1844,34 +827,34 @@ func (s *server) RemoveGroupPhoto() http.HandlerFunc {
 		userid, _ := strconv.Atoi(txtid)
 
 		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
 			return
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t removeGroupPhotoStruct
+		var t groupLeaveStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
 			return
 		}
 
-		_, err = clientPointer[userid].SetGroupPhoto(r.Context(), group, nil)
+		err = clientPointer[userid].LeaveGroup(r.Context(), group)
 
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to remove group photo")
-			msg := fmt.Sprintf("failed to remove group photo: %v", err)
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to leave group")
+			msg := fmt.Sprintf("Failed to leave group: %v", err)
 			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		response := map[string]interface{}{"Details": "Group Photo removed successfully"}
+		response := map[string]interface{}{"Details": "Group left successfully"}
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
@@ -1884,258 +867,160 @@ func (s *server) RemoveGroupPhoto() http.HandlerFunc {
 	}
 }
 
-// Sends Sticker message
-func (s *server) SendSticker() http.HandlerFunc {
-
-	type stickerStruct struct {
-		Phone        string
-		Sticker      string
-		Id           string
-		PngThumbnail []byte
-		ContextInfo  waProto.ContextInfo
-	}
-
-	return func(w http.ResponseWriter, r *http.Request) {
-
-		txtid := r.Context().Value("userinfo").(Values).Get("Id")
-		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
-		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
-			return
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t stickerStruct
-		err := decoder.Decode(&t)
-		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
-			return
-		}
-
-		if t.Sticker == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Sticker in Payload"))
-			return
-		}
-
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-
-		if t.Sticker[0:4] == "data" {
-			dataURL, err := dataurl.DecodeString(t.Sticker)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(r.Context(), filedata, whatsmeow.MediaImage)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to upload file: %v", err)))
-					return
-				}
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Data should start with \"data:mime/type;base64,\""))
-			return
-		}
-
-		msg := &waProto.Message{StickerMessage: &waProto.StickerMessage{
-			URL:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-			PngThumbnail:  t.PngThumbnail,
-		}}
-
-		if t.ContextInfo.StanzaID != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
-		}
-		if t.ContextInfo.MentionedJID != nil {
-			if msg.ExtendedTextMessage.ContextInfo == nil {
-				msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{}
-			}
-			msg.ExtendedTextMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
-		}
-
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
-			return
-		}
-
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
-		responseJson, err := json.Marshal(response)
-		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
-		} else {
-			s.Respond(w, r, http.StatusOK, string(responseJson))
-		}
-		return
-	}
-}
-
-// Sends Video message
-func (s *server) SendVideo() http.HandlerFunc {
+// SetGroupAnnounce post
+func (s *server) SetGroupAnnounce() http.HandlerFunc {
 
-	type imageStruct struct {
-		Phone         string
-		Video         string
-		Caption       string
-		Id            string
-		JPEGThumbnail []byte
-		ContextInfo   waProto.ContextInfo
+	type setGroupAnnounceStruct struct {
+		GroupJID string
+		Announce bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
-		msgid := ""
-		var resp whatsmeow.SendResponse
 
 		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t imageStruct
+		var t setGroupAnnounceStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
 			return
 		}
 
-		if t.Video == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Video in Payload"))
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
 			return
 		}
 
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
+		// Verify user is admin of the group
+		groupInfo, err := clientPointer[userid].GetGroupInfo(r.Context(), group)
 		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			log.Error().
+				Str("error", fmt.Sprintf("%v", err)).
+				Str("group", group.String()).
+				Msg("failed to get group info")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to get group info"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
+		// Check if user is admin
+		userJID := clientPointer[userid].Store.ID
+		isAdmin := false
+
+		// Normalize user JID by removing device suffix (e.g., :53)
+		userJIDStr := userJID.String()
+		if idx := strings.Index(userJIDStr, ":"); idx != -1 {
+			userJIDStr = userJIDStr[:idx] + "@" + strings.Split(userJIDStr, "@")[1]
 		}
 
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
+		log.Info().
+			Str("user_jid", userJID.String()).
+			Str("user_jid_normalized", userJIDStr).
+			Str("group", group.String()).
+			Int("participants_count", len(groupInfo.Participants)).
+			Msg("checking admin status")
 
-		if t.Video[0:4] == "data" {
-			dataURL, err := dataurl.DecodeString(t.Video)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(r.Context(), filedata, whatsmeow.MediaVideo)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to upload file: %v", err)))
-					return
-				}
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Data should start with \"data:mime/type;base64,\""))
-			return
-		}
+		for i, participant := range groupInfo.Participants {
+			participantJIDStr := participant.JID.String()
+			jidsMatch := participantJIDStr == userJIDStr
 
-		msg := &waProto.Message{VideoMessage: &waProto.VideoMessage{
-			Caption:       proto.String(t.Caption),
-			URL:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
-			JPEGThumbnail: t.JPEGThumbnail,
-		}}
+			log.Info().
+				Int("participant_index", i).
+				Str("participant_jid", participantJIDStr).
+				Bool("is_admin", participant.IsAdmin).
+				Str("user_jid_normalized", userJIDStr).
+				Bool("jids_match", jidsMatch).
+				Msg("checking participant")
 
-		if t.ContextInfo.StanzaID != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
+			if jidsMatch && participant.IsAdmin {
+				isAdmin = true
+				break
 			}
 		}
-		if t.ContextInfo.MentionedJID != nil {
-			if msg.ExtendedTextMessage.ContextInfo == nil {
-				msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{}
-			}
-			msg.ExtendedTextMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
+
+		if !isAdmin {
+			log.Warn().
+				Str("group", group.String()).
+				Str("user", userJID.String()).
+				Msg("user is not admin of the group")
+			s.Respond(w, r, http.StatusForbidden, errors.New("only group admins can change announcement settings"))
+			return
 		}
 
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		log.Info().
+			Str("group", group.String()).
+			Bool("announce", t.Announce).
+			Str("user", userJID.String()).
+			Msg("attempting to set group announce")
+
+		err = clientPointer[userid].SetGroupAnnounce(r.Context(), group, t.Announce)
+
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			log.Error().
+				Str("error", fmt.Sprintf("%v", err)).
+				Str("group", group.String()).
+				Bool("announce", t.Announce).
+				Str("user", userJID.String()).
+				Msg("failed to set group announce")
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		// Verify the change was applied by getting group info again
+		updatedGroupInfo, err := clientPointer[userid].GetGroupInfo(r.Context(), group)
+		if err != nil {
+			log.Warn().
+				Str("error", fmt.Sprintf("%v", err)).
+				Msg("failed to verify group announce change")
+		} else {
+			log.Info().
+				Str("group", group.String()).
+				Bool("requested_announce", t.Announce).
+				Bool("actual_announce", updatedGroupInfo.IsAnnounce).
+				Str("user", userJID.String()).
+				Msg("group announce status after change")
+		}
+
+		log.Info().
+			Str("group", group.String()).
+			Bool("announce", t.Announce).
+			Str("user", userJID.String()).
+			Msg("successfully set group announce")
+
+		response := map[string]interface{}{"Details": "Group Announce set successfully"}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+
 		return
 	}
 }
 
-// Sends Contact
-func (s *server) SendContact() http.HandlerFunc {
+// add, remove, promote and demote members group
+func (s *server) UpdateGroupParticipants() http.HandlerFunc {
 
-	type contactStruct struct {
-		Phone       string
-		Id          string
-		Name        string
-		Vcard       string
-		ContextInfo waProto.ContextInfo
+	type updateGroupParticipantsStruct struct {
+		GroupJID     string
+		Participants []string
+		// Action string // add, remove, promote, demote
+		Action string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
 		userid, _ := strconv.Atoi(txtid)
 
 		if clientPointer[userid] == nil {
@@ -2143,94 +1028,88 @@ func (s *server) SendContact() http.HandlerFunc {
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
 		decoder := json.NewDecoder(r.Body)
-		var t contactStruct
+		var t updateGroupParticipantsStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
 			return
 		}
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
 			return
 		}
-		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Name in Payload"))
+
+		if len(t.Participants) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
 			return
 		}
-		if t.Vcard == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Vcard in Payload"))
-			return
+		// parse phone numbers
+		phoneParsed := make([]types.JID, len(t.Participants))
+		for i, phone := range t.Participants {
+			phoneParsed[i], ok = parseJID(phone)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Phone"))
+				return
+			}
 		}
 
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+		if t.Action == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Action in Payload"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		msg := &waProto.Message{ContactMessage: &waProto.ContactMessage{
-			DisplayName: &t.Name,
-			Vcard:       &t.Vcard,
-		}}
+		// parse action
 
-		if t.ContextInfo.StanzaID != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
-		}
-		if t.ContextInfo.MentionedJID != nil {
-			if msg.ExtendedTextMessage.ContextInfo == nil {
-				msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{}
-			}
-			msg.ExtendedTextMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
+		var action whatsmeow.ParticipantChange
+		switch t.Action {
+		case "add":
+			action = "add"
+		case "remove":
+			action = "remove"
+		case "promote":
+			action = "promote"
+		case "demote":
+			action = "demote"
+		default:
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Invalid Action in Payload"))
+			return
 		}
 
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		_, err = clientPointer[userid].UpdateGroupParticipants(r.Context(), group, phoneParsed, action)
+
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to change participant group")
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		response := map[string]interface{}{"Details": "Group Participants updated successfully"}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+
 		return
 	}
 }
 
-// Sends location
-func (s *server) SendLocation() http.HandlerFunc {
+// Get group invite info
+func (s *server) GetGroupInviteInfo() http.HandlerFunc {
 
-	type locationStruct struct {
-		Phone       string
-		Id          string
-		Name        string
-		Latitude    float64
-		Longitude   float64
-		ContextInfo waProto.ContextInfo
+	type getGroupInviteInfoStruct struct {
+		Code string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
 		userid, _ := strconv.Atoi(txtid)
 
 		if clientPointer[userid] == nil {
@@ -2238,98 +1117,53 @@ func (s *server) SendLocation() http.HandlerFunc {
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
 		decoder := json.NewDecoder(r.Body)
-		var t locationStruct
+		var t getGroupInviteInfoStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
 			return
 		}
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
-			return
-		}
-		if t.Latitude == 0 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Latitude in Payload"))
-			return
-		}
-		if t.Longitude == 0 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Longitude in Payload"))
-			return
-		}
 
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+		if t.Code == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Code in Payload"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		msg := &waProto.Message{LocationMessage: &waProto.LocationMessage{
-			DegreesLatitude:  &t.Latitude,
-			DegreesLongitude: &t.Longitude,
-			Name:             &t.Name,
-		}}
-
-		if t.ContextInfo.StanzaID != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
-		}
-		if t.ContextInfo.MentionedJID != nil {
-			if msg.ExtendedTextMessage.ContextInfo == nil {
-				msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{}
-			}
-			msg.ExtendedTextMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
-		}
+		groupInfo, err := clientPointer[userid].GetGroupInfoFromLink(r.Context(), t.Code)
 
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group invite info")
+			msg := fmt.Sprintf("Failed to get group invite info: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
-		responseJson, err := json.Marshal(response)
+		responseJson, err := json.Marshal(groupInfo)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+
 		return
 	}
 }
 
-// Sends Buttons (not implemented, does not work)
-
-func (s *server) SendButtons() http.HandlerFunc {
+// Create group
+func (s *server) CreateGroup() http.HandlerFunc {
 
-	type buttonStruct struct {
-		ButtonId   string
-		ButtonText string
-	}
-	type textStruct struct {
-		Phone   string
-		Title   string
-		Buttons []buttonStruct
-		Id      string
+	type createGroupStruct struct {
+		Name            string   `json:"name"`
+		Participants    []string `json:"participants"`
+		LinkedParentJID string   `json:"linkedparentjid"` // create the group inside this community
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
 		userid, _ := strconv.Atoi(txtid)
 
 		if clientPointer[userid] == nil {
@@ -2337,241 +1171,135 @@ func (s *server) SendButtons() http.HandlerFunc {
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
 		decoder := json.NewDecoder(r.Body)
-		var t textStruct
+		var t createGroupStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
 			return
 		}
 
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
-			return
-		}
-
-		if t.Title == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Title in Payload"))
-			return
-		}
-
-		if len(t.Buttons) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Buttons in Payload"))
-			return
-		}
-		if len(t.Buttons) > 3 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("buttons cant more than 3"))
-			return
-		}
-
-		recipient, ok := parseJID(t.Phone)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Phone"))
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Name in Payload"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
+		if len(t.Participants) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Participants in Payload"))
+			return
 		}
 
-		var buttons []*waProto.ButtonsMessage_Button
-
-		for _, item := range t.Buttons {
-			buttons = append(buttons, &waProto.ButtonsMessage_Button{
-				ButtonID:       proto.String(item.ButtonId),
-				ButtonText:     &waProto.ButtonsMessage_Button_ButtonText{DisplayText: proto.String(item.ButtonText)},
-				Type:           waProto.ButtonsMessage_Button_RESPONSE.Enum(),
-				NativeFlowInfo: &waProto.ButtonsMessage_Button_NativeFlowInfo{},
-			})
+		// Parse participant phone numbers
+		participantJIDs := make([]types.JID, len(t.Participants))
+		var ok bool
+		for i, phone := range t.Participants {
+			participantJIDs[i], ok = parseJID(phone)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Participant Phone"))
+				return
+			}
 		}
 
-		msg2 := &waProto.ButtonsMessage{
-			ContentText: proto.String(t.Title),
-			HeaderType:  waProto.ButtonsMessage_EMPTY.Enum(),
-			Buttons:     buttons,
+		req := whatsmeow.ReqCreateGroup{
+			Name:         t.Name,
+			Participants: participantJIDs,
+		}
+		if t.LinkedParentJID != "" {
+			req.LinkedParentJID, ok = parseJID(t.LinkedParentJID)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse LinkedParentJID"))
+				return
+			}
 		}
 
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{
-			Message: &waProto.Message{
-				ButtonsMessage: msg2,
-			},
-		}}, whatsmeow.SendRequestExtra{ID: msgid})
+		groupInfo, err := clientPointer[userid].CreateGroup(r.Context(), req)
+
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to create group")
+			msg := fmt.Sprintf("Failed to create group: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
-		responseJson, err := json.Marshal(response)
+		responseJson, err := json.Marshal(groupInfo)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+
 		return
 	}
 }
 
-// SendList
-// https://github.com/tulir/whatsmeow/issues/305
-func (s *server) SendList() http.HandlerFunc {
-
-	type rowsStruct struct {
-		RowId       string
-		Title       string
-		Description string
-	}
-
-	type sectionsStruct struct {
-		Title string
-		Rows  []rowsStruct
-	}
+// Set group locked
+func (s *server) SetGroupLocked() http.HandlerFunc {
 
-	type listStruct struct {
-		Phone       string
-		Title       string
-		Description string
-		ButtonText  string
-		FooterText  string
-		Sections    []sectionsStruct
-		Id          string
+	type setGroupLockedStruct struct {
+		GroupJID string `json:"groupjid"`
+		Locked   bool   `json:"locked"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
 		userid, _ := strconv.Atoi(txtid)
 
 		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
 		decoder := json.NewDecoder(r.Body)
-		var t listStruct
+		var t setGroupLockedStruct
 		err := decoder.Decode(&t)
-		marshal, _ := json.Marshal(t)
-		fmt.Println(string(marshal))
 		if err != nil {
-			fmt.Println(err)
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
-			return
-		}
-
-		if t.Title == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Title in Payload"))
-			return
-		}
-
-		if t.Description == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Description in Payload"))
-			return
-		}
-
-		if t.ButtonText == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing ButtonText in Payload"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
 			return
 		}
 
-		if len(t.Sections) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Sections in Payload"))
-			return
-		}
-		recipient, ok := parseJID(t.Phone)
+		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var sections []*waProto.ListMessage_Section
-
-		for _, item := range t.Sections {
-			var rows []*waProto.ListMessage_Row
-			id := 1
-			for _, row := range item.Rows {
-				var idtext string
-				if row.RowId == "" {
-					idtext = strconv.Itoa(id)
-				} else {
-					idtext = row.RowId
-				}
-				rows = append(rows, &waProto.ListMessage_Row{
-					RowID:       proto.String(idtext),
-					Title:       proto.String(row.Title),
-					Description: proto.String(row.Description),
-				})
-			}
+		err = clientPointer[userid].SetGroupLocked(r.Context(), group, t.Locked)
 
-			sections = append(sections, &waProto.ListMessage_Section{
-				Title: proto.String(item.Title),
-				Rows:  rows,
-			})
-		}
-		msg1 := &waProto.ListMessage{
-			Title:       proto.String(t.Title),
-			Description: proto.String(t.Description),
-			ButtonText:  proto.String(t.ButtonText),
-			ListType:    waProto.ListMessage_SINGLE_SELECT.Enum(),
-			Sections:    sections,
-			FooterText:  proto.String(t.FooterText),
-		}
-
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, &waProto.Message{
-			ViewOnceMessage: &waProto.FutureProofMessage{
-				Message: &waProto.Message{
-					ListMessage: msg1,
-				},
-			}}, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group locked")
+			msg := fmt.Sprintf("Failed to set group locked: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		response := map[string]interface{}{"Details": "Group Locked setting updated successfully"}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+
 		return
 	}
 }
 
-// Sends a regular text message
-func (s *server) SendMessage() http.HandlerFunc {
+// Set disappearing timer (ephemeral messages)
+func (s *server) SetDisappearingTimer() http.HandlerFunc {
 
-	type textStruct struct {
-		Phone       string
-		Body        string
-		Id          string
-		ContextInfo waProto.ContextInfo
+	type setDisappearingTimerStruct struct {
+		GroupJID string `json:"groupjid"`
+		Duration string `json:"duration"` // "24h", "7d", "90d", "off"
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
 		userid, _ := strconv.Atoi(txtid)
 
 		if clientPointer[userid] == nil {
@@ -2579,71 +1307,53 @@ func (s *server) SendMessage() http.HandlerFunc {
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-
 		decoder := json.NewDecoder(r.Body)
-		var t textStruct
+		var t setDisappearingTimerStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
 			return
 		}
 
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
 			return
 		}
 
-		if t.Body == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Body in Payload"))
+		if t.Duration == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Duration in Payload"))
 			return
 		}
 
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
-		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+		var duration time.Duration
+		switch t.Duration {
+		case "24h":
+			duration = 24 * time.Hour
+		case "7d":
+			duration = 7 * 24 * time.Hour
+		case "90d":
+			duration = 90 * 24 * time.Hour
+		case "off":
+			duration = 0
+		default:
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Invalid duration. Use: 24h, 7d, 90d, or off"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		//	msg := &waProto.Message{Conversation: &t.Body}
-
-		msg := &waProto.Message{
-			ExtendedTextMessage: &waProto.ExtendedTextMessage{
-				Text: &t.Body,
-			},
-		}
-
-		if t.ContextInfo.StanzaID != nil {
-			msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{
-				StanzaID:      proto.String(*t.ContextInfo.StanzaID),
-				Participant:   proto.String(*t.ContextInfo.Participant),
-				QuotedMessage: &waProto.Message{Conversation: proto.String("")},
-			}
-		}
-		if t.ContextInfo.MentionedJID != nil {
-			if msg.ExtendedTextMessage.ContextInfo == nil {
-				msg.ExtendedTextMessage.ContextInfo = &waProto.ContextInfo{}
-			}
-			msg.ExtendedTextMessage.ContextInfo.MentionedJID = t.ContextInfo.MentionedJID
-		}
+		err = clientPointer[userid].SetDisappearingTimer(r.Context(), group, duration, time.Now())
 
-		resp, err = clientPointer[userid].SendMessage(r.Context(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set disappearing timer")
+			msg := fmt.Sprintf("Failed to set disappearing timer: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
+		setEphemeralTimer(s.db, userid, group, uint32(duration.Seconds()))
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		response := map[string]interface{}{"Details": "Disappearing timer set successfully"}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
@@ -2654,165 +1364,102 @@ func (s *server) SendMessage() http.HandlerFunc {
 	}
 }
 
-/*
-// Sends a Template message
-func (s *server) SendTemplate() http.HandlerFunc {
-
-	type buttonStruct struct {
-		DisplayText string
-		Id          string
-		Url         string
-		PhoneNumber string
-		Type        string
-	}
+// Remove group photo
+func (s *server) RemoveGroupPhoto() http.HandlerFunc {
 
-	type templateStruct struct {
-		Phone   string
-		Content string
-		Footer  string
-		Id      string
-		Buttons []buttonStruct
+	type removeGroupPhotoStruct struct {
+		GroupJID string `json:"groupjid"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
 		userid, _ := strconv.Atoi(txtid)
 
 		if clientPointer[userid] == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		msgid := ""
-		var resp whatsmeow.SendResponse
-//var ts time.Time
-
 		decoder := json.NewDecoder(r.Body)
-		var t templateStruct
+		var t removeGroupPhotoStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
-			return
-		}
-
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
-			return
-		}
-
-		if t.Content == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Content in Payload"))
-			return
-		}
-
-		if t.Footer == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Footer in Payload"))
-			return
-		}
-
-		if len(t.Buttons) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Buttons in Payload"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
 			return
 		}
 
-		recipient, ok := parseJID(t.Phone)
+		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Phone"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
-		}
-
-		var buttons []*waProto.HydratedTemplateButton
-
-		id := 1
-		for _, item := range t.Buttons {
-			switch item.Type {
-			case "quickreply":
-				var idtext string
-				text := item.DisplayText
-				if item.Id == "" {
-					idtext = strconv.Itoa(id)
-				} else {
-					idtext = item.Id
-				}
-				buttons = append(buttons, &waProto.HydratedTemplateButton{
-					HydratedButton: &waProto.HydratedTemplateButton_QuickReplyButton{
-						QuickReplyButton: &waProto.HydratedQuickReplyButton{
-							DisplayText: &text,
-							Id:          proto.String(idtext),
-						},
-					},
-				})
-			case "url":
-				text := item.DisplayText
-				url := item.Url
-				buttons = append(buttons, &waProto.HydratedTemplateButton{
-					HydratedButton: &waProto.HydratedTemplateButton_UrlButton{
-						UrlButton: &waProto.HydratedURLButton{
-							DisplayText: &text,
-							Url:         &url,
-						},
-					},
-				})
-			case "call":
-				text := item.DisplayText
-				phonenumber := item.PhoneNumber
-				buttons = append(buttons, &waProto.HydratedTemplateButton{
-					HydratedButton: &waProto.HydratedTemplateButton_CallButton{
-						CallButton: &waProto.HydratedCallButton{
-							DisplayText: &text,
-							PhoneNumber: &phonenumber,
-						},
-					},
-				})
-			default:
-				text := item.DisplayText
-				buttons = append(buttons, &waProto.HydratedTemplateButton{
-					HydratedButton: &waProto.HydratedTemplateButton_QuickReplyButton{
-						QuickReplyButton: &waProto.HydratedQuickReplyButton{
-							DisplayText: &text,
-							Id:          proto.String(string(id)),
-						},
-					},
-				})
-			}
-			id++
-		}
-
-		msg := &waProto.Message{TemplateMessage: &waProto.TemplateMessage{
-			HydratedTemplate: &waProto.HydratedFourRowTemplate{
-				HydratedContentText: proto.String(t.Content),
-				HydratedFooterText:  proto.String(t.Footer),
-				HydratedButtons:     buttons,
-				TemplateId:          proto.String("1"),
-			},
-		},
-		}
+		_, err = clientPointer[userid].SetGroupPhoto(r.Context(), group, nil)
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(),recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to remove group photo")
+			msg := fmt.Sprintf("failed to remove group photo: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		response := map[string]interface{}{"Details": "Group Photo removed successfully"}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+
 		return
 	}
 }
-*/
+
+// Sends Sticker message
+func (s *server) SendSticker() http.HandlerFunc {
+	return s.sendAs("sticker")
+}
+
+// Sends Video message
+func (s *server) SendVideo() http.HandlerFunc {
+	return s.sendAs("video")
+}
+
+// Sends Contact
+func (s *server) SendContact() http.HandlerFunc {
+	return s.sendAs("contact")
+}
+
+// Sends location
+func (s *server) SendLocation() http.HandlerFunc {
+	return s.sendAs("location")
+}
+
+// Sends Buttons, legacy message most clients no longer show, see SendInteractive
+
+func (s *server) SendButtons() http.HandlerFunc {
+	return s.sendAs("buttons")
+}
+
+// SendList, legacy message most clients no longer show, see SendInteractive
+// https://github.com/tulir/whatsmeow/issues/305
+func (s *server) SendList() http.HandlerFunc {
+	return s.sendAs("list")
+}
+
+// Sends a message with native flow buttons or a list
+func (s *server) SendInteractive() http.HandlerFunc {
+	return s.sendAs("interactive")
+}
+
+// Sends a regular text message
+func (s *server) SendMessage() http.HandlerFunc {
+	return s.sendAs("text")
+}
+
 // checks if users/phones are on Whatsapp
 func (s *server) CheckUser() http.HandlerFunc {
 
@@ -3541,7 +2188,7 @@ func (s *server) Edit() http.HandlerFunc {
 			return
 		}
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Edit sent")
+		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Edit sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
@@ -3617,7 +2264,7 @@ func (s *server) Revoke() http.HandlerFunc {
 			return
 		}
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Revoke sent")
+		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Revoke sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
@@ -3694,7 +2341,7 @@ func (s *server) React() http.HandlerFunc {
 			return
 		}
 
-		log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Reaction sent")
+		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Reaction sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
@@ -3774,7 +2421,7 @@ func (s *server) React() http.HandlerFunc {
 // 				return
 // 			}
 // 			// Registro e resposta
-// 			log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Reaction sent")
+// 			log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Reaction sent")
 // 			response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
 // 			responseJson, err := json.Marshal(response)
 // 			if err != nil {
@@ -3791,7 +2438,7 @@ func (s *server) React() http.HandlerFunc {
 // 				return
 // 			}
 // 			// Registro e resposta
-// 			log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Reaction sent")
+// 			log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Reaction sent")
 // 			response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
 // 			responseJson, err := json.Marshal(response)
 // 			if err != nil {
@@ -3945,7 +2592,22 @@ func (s *server) GetGroupInfo() http.HandlerFunc {
 			return
 		}
 
-		responseJson, err := json.Marshal(resp)
+		cacheGroupInfo(userid, resp)
+
+		// Communities also list their subgroups
+		var info interface{} = resp
+		if resp.IsParent {
+			subgroups, err := clientPointer[userid].GetSubGroups(r.Context(), group)
+			if err != nil {
+				log.Warn().Err(err).Str("community", group.String()).Msg("Could not get community subgroups")
+			}
+			info = struct {
+				*types.GroupInfo
+				Subgroups []*types.GroupLinkTarget
+			}{resp, subgroups}
+		}
+
+		responseJson, err := json.Marshal(info)
 
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -4437,26 +3099,94 @@ func (s *server) Respond(w http.ResponseWriter, r *http.Request, status int, dat
 	}
 }
 
-func validateMessageFields(phone string, stanzaid *string, participant *string) (types.JID, error) {
+// Delivery options accepted by every /chat/send/* payload
+type sendOptions struct {
+	Async          bool   // Queue the message and return a job id instead of waiting for the send
+	SendAt         string // Schedule the message, RFC3339 or local time of the instance
+	SimulateTyping *bool  // Show typing in the chat before sending, the instance setting when not given
+}
+
+// Sends a message right away, or stores it in the instance's outbound queue
+// when the payload asked for asynchronous or scheduled delivery
+func (s *server) deliver(w http.ResponseWriter, r *http.Request, userid int, recipient types.JID, msg *waProto.Message, msgid string, opts sendOptions) {
 
-	recipient, ok := parseJID(phone)
-	if !ok {
-		return types.NewJID("", types.DefaultUserServer), errors.New("Could not parse Phone")
+	typing := shouldSimulateTyping(s.db, userid, recipient, opts)
+
+	sendAt, err := scheduledAt(s.db, userid, opts)
+	if err != nil {
+		s.Respond(w, r, http.StatusBadRequest, err)
+		return
 	}
+	if sendAt != nil {
+		jobid, err := s.enqueueMessage(userid, recipient, msgid, msg, sendAt, typing)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not schedule message: %v", err)))
+			return
+		}
 
-	if stanzaid != nil {
-		if participant == nil {
-			return types.NewJID("", types.DefaultUserServer), errors.New("Missing Participant in ContextInfo")
+		log.Info().Str("job", jobid).Str("id", msgid).Time("send_at", *sendAt).Msg("Message scheduled")
+		response := map[string]interface{}{"Details": "Scheduled", "JobId": jobid, "Id": msgid, "SendAt": sendAt}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusAccepted, string(responseJson))
 		}
+		return
 	}
 
-	if participant != nil {
-		if stanzaid == nil {
-			return types.NewJID("", types.DefaultUserServer), errors.New("Missing StanzaID in ContextInfo")
+	if opts.Async {
+		jobid, err := s.enqueueMessage(userid, recipient, msgid, msg, nil, typing)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not queue message: %v", err)))
+			return
+		}
+
+		log.Info().Str("job", jobid).Str("id", msgid).Msg("Message queued")
+		response := map[string]interface{}{"Details": "Queued", "JobId": jobid, "Id": msgid}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusAccepted, string(responseJson))
 		}
+		return
+	}
+
+	resp, err := s.sendNow(r.Context(), userid, recipient, msg, msgid, typing)
+	if err != nil {
+		s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+		return
 	}
 
-	return recipient, nil
+	log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
+	response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		s.Respond(w, r, http.StatusInternalServerError, err)
+	} else {
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Sends a message right away, showing typing first when asked, and stores it
+func (s *server) sendNow(ctx context.Context, userid int, recipient types.JID, msg *waProto.Message, msgid string, typing bool) (whatsmeow.SendResponse, error) {
+	client := clientPointer[userid]
+	if client == nil {
+		return whatsmeow.SendResponse{}, errors.New("No session")
+	}
+	if typing {
+		showTyping(ctx, client, recipient, msg)
+	}
+	resp, err := client.SendMessage(ctx, recipient, msg, sendRequestExtra(msg, msgid))
+	if typing {
+		stopTyping(client, recipient)
+	}
+	if err != nil {
+		return resp, err
+	}
+	storeSentMessage(s.db, userid, client, recipient, msgid, msg, resp.Timestamp)
+	return resp, nil
 }
 
 func contains(slice []string, item string) bool {
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
@@ -45,6 +46,39 @@ func callHook(myurl string, payload map[string]string, id int) {
 	}
 }
 
+// webhook for events generated by wuzapi itself, honouring the instance subscriptions
+func sendEventWebhook(userID int, token string, eventType string, event interface{}) {
+	myuserinfo, found := userinfocache.Get(token)
+	if !found {
+		log.Warn().Str("token", token).Msg("Could not call webhook as there is no user for this token")
+		return
+	}
+
+	webhookurl := myuserinfo.(Values).Get("Webhook")
+	if webhookurl == "" || clientHttp[userID] == nil {
+		return
+	}
+
+	subscriptions := strings.Split(myuserinfo.(Values).Get("Events"), ",")
+	if !Find(subscriptions, eventType) && !Find(subscriptions, "All") {
+		log.Debug().Str("type", eventType).Msg("Skipping webhook. Not subscribed for this type")
+		return
+	}
+
+	postmap := map[string]interface{}{"type": eventType, "event": event}
+	values, err := json.Marshal(postmap)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal JSON for webhook")
+		return
+	}
+
+	data := map[string]string{
+		"jsonData": string(values),
+		"token":    token,
+	}
+	go callHook(webhookurl, data, userID)
+}
+
 func callHookFile(txtid string, data map[string]string, fileName, webhookURL string) error {
 	// Build the user directory path
 	userDirectory := filepath.Join("./", "files", "user_"+txtid)
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// Send requests carrying an Idempotency-Key header, or a caller supplied
+// message Id, are recorded in the idempotency_keys table together with their
+// response. A retry with the same key within the window gets the stored
+// response back instead of sending the message again, as long as the payload
+// is the same. Keys live in the database so they hold when several replicas
+// serve the same instance.
+
+const idempotencyPurgeInterval = time.Hour
+
+// Records the status and body written by the wrapped handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Returns the idempotency key of a send request and the hash of its
+// payload. The Idempotency-Key header takes precedence over the message Id
+// in the payload.
+func idempotencyKey(r *http.Request) (string, string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		return "key:" + key, hash, nil
+	}
+
+	var payload struct {
+		Id string
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Id == "" {
+		// Invalid payloads are rejected by the handler itself
+		return "", "", nil
+	}
+	return "id:" + payload.Id, hash, nil
+}
+
+// Middleware for the send endpoints that replays the original response of
+// a request already processed with the same key
+func (s *server) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		key, hash, err := idempotencyKey(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not read Payload"))
+			return
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Claim the key, taking over entries older than the window
+		var claimed string
+		err = s.db.Get(&claimed, `INSERT INTO idempotency_keys (user_id, idem_key, request_hash) VALUES ($1, $2, $4)
+			ON CONFLICT (user_id, idem_key) DO UPDATE SET status_code=NULL, response='', request_hash=EXCLUDED.request_hash, created_at=NOW()
+			WHERE idempotency_keys.created_at < NOW() - make_interval(secs => $3)
+			RETURNING idem_key`, userid, key, *idempotencyWindow, hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.replayResponse(w, r, userid, key, hash)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg("Could not store idempotency key")
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("Could not store idempotency key"))
+			return
+		}
+
+		release := func() error {
+			_, err := s.db.Exec("DELETE FROM idempotency_keys WHERE user_id=$1 AND idem_key=$2", userid, key)
+			return err
+		}
+		// A panicking handler must not leave the key in progress for the whole window
+		defer func() {
+			if p := recover(); p != nil {
+				if err := release(); err != nil {
+					log.Error().Err(err).Str("key", key).Msg("Could not release idempotency key")
+				}
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= 200 && rec.status < 300 {
+			_, err = s.db.Exec("UPDATE idempotency_keys SET status_code=$1, response=$2 WHERE user_id=$3 AND idem_key=$4",
+				rec.status, rec.body.String(), userid, key)
+		} else {
+			// Nothing was sent, let the caller retry with the same key
+			err = release()
+		}
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg("Could not update idempotency key")
+		}
+	})
+}
+
+// Writes back the response stored for a key
+func (s *server) replayResponse(w http.ResponseWriter, r *http.Request, userid int, key string, hash string) {
+	var stored struct {
+		StatusCode  sql.NullInt64 `db:"status_code"`
+		Response    string        `db:"response"`
+		RequestHash string        `db:"request_hash"`
+	}
+	err := s.db.Get(&stored, "SELECT status_code, response, request_hash FROM idempotency_keys WHERE user_id=$1 AND idem_key=$2", userid, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The original request failed meanwhile and released the key
+		s.Respond(w, r, http.StatusConflict, errors.New("Request with the same idempotency key failed, please retry"))
+		return
+	}
+	if err != nil {
+		s.Respond(w, r, http.StatusInternalServerError, errors.New("Could not load idempotency key"))
+		return
+	}
+	if stored.RequestHash != hash {
+		s.Respond(w, r, http.StatusUnprocessableEntity, errors.New("Idempotency key was already used with a different payload"))
+		return
+	}
+	if !stored.StatusCode.Valid {
+		s.Respond(w, r, http.StatusConflict, errors.New("Request with the same idempotency key is still in progress"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(stored.StatusCode.Int64))
+	io.WriteString(w, stored.Response)
+}
+
+// Removes expired idempotency keys from time to time
+func purgeIdempotencyKeys(db *sqlx.DB) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_, err := db.Exec("DELETE FROM idempotency_keys WHERE created_at < NOW() - make_interval(secs => $1)", *idempotencyWindow)
+		if err != nil {
+			log.Error().Err(err).Msg("Could not purge idempotency keys")
+		}
+	}
+}
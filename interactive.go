@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Interactive messages carry native flow buttons, which current clients
+// render unlike the legacy buttons and list messages. Every button has a
+// name and its parameters as JSON, a list is a single_select button.
+
+const interactiveMaxButtons = 10
+
+// Builds an interactive message with native flow buttons
+func buildInteractiveMessage(ctx context.Context, client *whatsmeow.Client, c messageContent) (*waProto.Message, error) {
+	if c.Body == "" {
+		return nil, errors.New("Missing Body in Payload")
+	}
+	if len(c.Buttons) == 0 && len(c.Sections) == 0 {
+		return nil, errors.New("Missing Buttons or Sections in Payload")
+	}
+	if len(c.Buttons) > interactiveMaxButtons {
+		return nil, fmt.Errorf("Interactive messages can have at most %d Buttons", interactiveMaxButtons)
+	}
+
+	var buttons []*waProto.InteractiveMessage_NativeFlowMessage_NativeFlowButton
+	for i, item := range c.Buttons {
+		button, err := nativeFlowButton(i, item)
+		if err != nil {
+			return nil, err
+		}
+		buttons = append(buttons, button)
+	}
+	if len(c.Sections) > 0 {
+		button, err := nativeFlowList(c)
+		if err != nil {
+			return nil, err
+		}
+		buttons = append(buttons, button)
+	}
+
+	interactive := &waProto.InteractiveMessage{
+		Body: &waProto.InteractiveMessage_Body{Text: proto.String(c.Body)},
+		InteractiveMessage: &waProto.InteractiveMessage_NativeFlowMessage_{
+			NativeFlowMessage: &waProto.InteractiveMessage_NativeFlowMessage{
+				Buttons:           buttons,
+				MessageParamsJSON: proto.String("{}"),
+				MessageVersion:    proto.Int32(1),
+			},
+		},
+	}
+	if c.FooterText != "" {
+		interactive.Footer = &waProto.InteractiveMessage_Footer{Text: proto.String(c.FooterText)}
+	}
+
+	// A title and an image, video or document can be shown above the text
+	header := &waProto.InteractiveMessage_Header{Title: proto.String(c.Title), HasMediaAttachment: proto.Bool(false)}
+	switch {
+	case c.Image != "":
+		media, err := buildImageMessage(ctx, client, messageContent{Image: c.Image})
+		if err != nil {
+			return nil, err
+		}
+		header.Media = &waProto.InteractiveMessage_Header_ImageMessage{ImageMessage: media.ImageMessage}
+	case c.Video != "":
+		media, err := buildVideoMessage(ctx, client, messageContent{Video: c.Video})
+		if err != nil {
+			return nil, err
+		}
+		header.Media = &waProto.InteractiveMessage_Header_VideoMessage{VideoMessage: media.VideoMessage}
+	case c.Document != "":
+		media, err := buildDocumentMessage(ctx, client, messageContent{Document: c.Document, FileName: c.FileName, MimeType: c.MimeType})
+		if err != nil {
+			return nil, err
+		}
+		header.Media = &waProto.InteractiveMessage_Header_DocumentMessage{DocumentMessage: media.DocumentMessage}
+	}
+	if header.Media != nil {
+		header.HasMediaAttachment = proto.Bool(true)
+	}
+	if c.Title != "" || header.Media != nil {
+		interactive.Header = header
+	}
+
+	return &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{
+		Message: &waProto.Message{
+			MessageContextInfo: &waProto.MessageContextInfo{
+				DeviceListMetadata:        &waE2E.DeviceListMetadata{},
+				DeviceListMetadataVersion: proto.Int32(2),
+			},
+			InteractiveMessage: interactive,
+		},
+	}}, nil
+}
+
+// Builds a quick reply, URL, call or copy code button
+func nativeFlowButton(i int, item messageButton) (*waProto.InteractiveMessage_NativeFlowMessage_NativeFlowButton, error) {
+	if item.ButtonText == "" {
+		return nil, fmt.Errorf("Missing ButtonText of button %d", i)
+	}
+	params := map[string]string{"display_text": item.ButtonText}
+	var name string
+	switch strings.ToLower(item.Type) {
+	case "", "reply":
+		name = "quick_reply"
+		params["id"] = item.ButtonId
+		if params["id"] == "" {
+			params["id"] = strconv.Itoa(i + 1)
+		}
+	case "url":
+		if !strings.HasPrefix(item.URL, "https://") && !strings.HasPrefix(item.URL, "http://") {
+			return nil, fmt.Errorf("Button %d needs an http or https URL", i)
+		}
+		name = "cta_url"
+		params["url"] = item.URL
+		params["merchant_url"] = item.URL
+	case "call":
+		digits := phoneDigits(item.PhoneNumber)
+		if len(digits) < 8 || len(digits) > 15 {
+			return nil, fmt.Errorf("Button %d needs a PhoneNumber of 8 to 15 digits with country code", i)
+		}
+		name = "cta_call"
+		params["phone_number"] = "+" + digits
+	case "copy":
+		if item.CopyCode == "" {
+			return nil, fmt.Errorf("Missing CopyCode of button %d", i)
+		}
+		name = "cta_copy"
+		params["id"] = item.ButtonId
+		params["copy_code"] = item.CopyCode
+	default:
+		return nil, fmt.Errorf("Invalid Type of button %d. Use: reply, url, call or copy", i)
+	}
+
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.InteractiveMessage_NativeFlowMessage_NativeFlowButton{
+		Name:             proto.String(name),
+		ButtonParamsJSON: proto.String(string(paramsJSON)),
+	}, nil
+}
+
+// Builds the button opening a list of sections
+func nativeFlowList(c messageContent) (*waProto.InteractiveMessage_NativeFlowMessage_NativeFlowButton, error) {
+	if c.ButtonText == "" {
+		return nil, errors.New("Missing ButtonText in Payload")
+	}
+
+	type row struct {
+		Header      string `json:"header"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		ID          string `json:"id"`
+	}
+	type section struct {
+		Title string `json:"title"`
+		Rows  []row  `json:"rows"`
+	}
+	list := struct {
+		Title    string    `json:"title"`
+		Sections []section `json:"sections"`
+	}{Title: c.ButtonText}
+
+	id := 1
+	for _, item := range c.Sections {
+		if len(item.Rows) == 0 {
+			return nil, fmt.Errorf("Section %q has no Rows", item.Title)
+		}
+		s := section{Title: item.Title}
+		for _, r := range item.Rows {
+			if r.Title == "" {
+				return nil, fmt.Errorf("Missing Title of a row in section %q", item.Title)
+			}
+			rowID := r.RowId
+			if rowID == "" {
+				rowID = strconv.Itoa(id)
+			}
+			id++
+			s.Rows = append(s.Rows, row{Title: r.Title, Description: r.Description, ID: rowID})
+		}
+		list.Sections = append(list.Sections, s)
+	}
+
+	paramsJSON, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.InteractiveMessage_NativeFlowMessage_NativeFlowButton{
+		Name:             proto.String("single_select"),
+		ButtonParamsJSON: proto.String(string(paramsJSON)),
+	}, nil
+}
+
+// Returns the send options of a message. Native flow messages are only
+// rendered when the stanza carries a biz node describing them.
+func sendRequestExtra(msg *waProto.Message, id string) whatsmeow.SendRequestExtra {
+	extra := whatsmeow.SendRequestExtra{ID: id}
+	if msg.GetViewOnceMessage().GetMessage().GetInteractiveMessage().GetNativeFlowMessage() == nil {
+		return extra
+	}
+	extra.AdditionalNodes = &[]waBinary.Node{{
+		Tag: "biz",
+		Content: []waBinary.Node{{
+			Tag:   "interactive",
+			Attrs: waBinary.Attrs{"type": "native_flow", "v": "1"},
+			Content: []waBinary.Node{{
+				Tag:   "native_flow",
+				Attrs: waBinary.Attrs{"v": "9", "name": "mixed"},
+			}},
+		}},
+	}}
+	return extra
+}
+
+// Posted with the InteractiveReply webhook
+type interactiveReply struct {
+	Id           string // id of the reply
+	Chat         string
+	Sender       string
+	PushName     string
+	ReplyTo      string // id of the message with the buttons or list
+	Type         string // button, list or native_flow
+	SelectedId   string
+	SelectedText string
+	Params       map[string]interface{} `json:",omitempty"` // native flow only, all parameters of the response
+	Timestamp    time.Time
+}
+
+// Reads the selection of a button, list or native flow reply. Returns nil
+// for any other message.
+func interactiveReplyOf(evt *events.Message) *interactiveReply {
+	reply := &interactiveReply{
+		Id:        evt.Info.ID,
+		Chat:      evt.Info.Chat.String(),
+		Sender:    evt.Info.Sender.ToNonAD().String(),
+		PushName:  evt.Info.PushName,
+		Timestamp: evt.Info.Timestamp,
+	}
+
+	msg := evt.Message
+	var contextInfo *waProto.ContextInfo
+	switch {
+	case msg.GetButtonsResponseMessage() != nil:
+		m := msg.GetButtonsResponseMessage()
+		reply.Type = "button"
+		reply.SelectedId = m.GetSelectedButtonID()
+		reply.SelectedText = m.GetSelectedDisplayText()
+		contextInfo = m.GetContextInfo()
+	case msg.GetTemplateButtonReplyMessage() != nil:
+		m := msg.GetTemplateButtonReplyMessage()
+		reply.Type = "button"
+		reply.SelectedId = m.GetSelectedID()
+		reply.SelectedText = m.GetSelectedDisplayText()
+		contextInfo = m.GetContextInfo()
+	case msg.GetListResponseMessage() != nil:
+		m := msg.GetListResponseMessage()
+		reply.Type = "list"
+		reply.SelectedId = m.GetSingleSelectReply().GetSelectedRowID()
+		reply.SelectedText = m.GetTitle()
+		contextInfo = m.GetContextInfo()
+	case msg.GetInteractiveResponseMessage() != nil:
+		m := msg.GetInteractiveResponseMessage()
+		reply.Type = "native_flow"
+		reply.SelectedText = m.GetBody().GetText()
+		contextInfo = m.GetContextInfo()
+		if response := m.GetNativeFlowResponseMessage(); response != nil {
+			if err := json.Unmarshal([]byte(response.GetParamsJSON()), &reply.Params); err == nil {
+				reply.SelectedId, _ = reply.Params["id"].(string)
+			}
+		}
+	default:
+		return nil
+	}
+	reply.ReplyTo = contextInfo.GetStanzaID()
+	return reply
+}
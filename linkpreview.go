@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"github.com/vincent-petithory/dataurl"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"golang.org/x/net/html"
+	"google.golang.org/protobuf/proto"
+)
+
+// Link previews are built from the Open Graph and Twitter card metadata of
+// the first URL in a text message. Pages and images are fetched with strict
+// limits through the instance proxy, and results are cached per URL.
+
+const (
+	previewTimeout   = 5 * time.Second
+	previewMaxPage   = 512 * 1024
+	previewMaxImage  = 2 * 1024 * 1024
+	previewUserAgent = "WhatsApp/2.23.20.0"
+)
+
+var (
+	urlPattern   = regexp.MustCompile(`https?://[^\s<>"']+`)
+	linkPreviews = cache.New(time.Hour, 10*time.Minute)
+)
+
+// Preview fields a caller can give instead of, or on top of, the fetched ones
+type linkPreview struct {
+	URL         string // link the preview is for, the first URL of the text when empty
+	Title       string
+	Description string
+	Image       string // data URL, resized to the preview thumbnail
+}
+
+type fetchedPreview struct {
+	Title       string
+	Description string
+	Thumbnail   []byte
+}
+
+// Returns the first URL of a text, without trailing punctuation
+func firstURL(text string) string {
+	link := urlPattern.FindString(text)
+	return strings.TrimRight(link, ".,;:!?)]}")
+}
+
+// Adds the preview of the first URL of a text message
+func (s *server) applyLinkPreview(ctx context.Context, userid int, msg *waProto.Message, t *sendRequest) error {
+	text := msg.GetExtendedTextMessage()
+	if text == nil {
+		return errors.New("Link previews are only supported for text messages")
+	}
+
+	var manual linkPreview
+	if t.Preview != nil {
+		manual = *t.Preview
+	}
+	link := manual.URL
+	if link == "" {
+		link = firstURL(text.GetText())
+	}
+	if link == "" {
+		return nil
+	}
+
+	var preview fetchedPreview
+	if t.LinkPreview {
+		// Without the proxy of the instance the page is not fetched, so the
+		// preview never goes out from the server address
+		var proxyURL string
+		if err := s.db.Get(&proxyURL, "SELECT COALESCE(proxy_url, '') FROM users WHERE id=$1", userid); err != nil {
+			log.Error().Err(err).Str("url", link).Msg("Could not load instance proxy, skipping link preview")
+		} else if fetched, err := fetchLinkPreview(ctx, link, proxyURL); err != nil {
+			log.Warn().Err(err).Str("url", link).Msg("Could not build link preview")
+		} else {
+			preview = *fetched
+		}
+	}
+
+	if manual.Title != "" {
+		preview.Title = manual.Title
+	}
+	if manual.Description != "" {
+		preview.Description = manual.Description
+	}
+	if manual.Image != "" {
+		if !strings.HasPrefix(manual.Image, "data:image") {
+			return errors.New("Preview Image data should start with \"data:image/jpeg;base64,\"")
+		}
+		dataURL, err := dataurl.DecodeString(manual.Image)
+		if err != nil {
+			return errors.New("Could not decode base64 encoded data from payload")
+		}
+		if preview.Thumbnail, err = makeThumbnail(dataURL.Data); err != nil {
+			return err
+		}
+	}
+
+	if preview.Title == "" && preview.Description == "" && preview.Thumbnail == nil {
+		return nil
+	}
+	text.MatchedText = proto.String(link)
+	text.Title = proto.String(preview.Title)
+	text.Description = proto.String(preview.Description)
+	if preview.Thumbnail != nil {
+		text.JPEGThumbnail = preview.Thumbnail
+	}
+	text.PreviewType = waProto.ExtendedTextMessage_NONE.Enum()
+	return nil
+}
+
+// Fetches the metadata of a page, or returns it from the cache
+func fetchLinkPreview(ctx context.Context, link string, proxyURL string) (*fetchedPreview, error) {
+	if cached, found := linkPreviews.Get(link); found {
+		return cached.(*fetchedPreview), nil
+	}
+
+	page, err := url.Parse(link)
+	if err != nil || (page.Scheme != "http" && page.Scheme != "https") || page.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", link)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+	client, err := previewClient(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	body, contentType, err := previewGet(ctx, client, page.String(), previewMaxPage)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(contentType, "text/html") && !strings.HasPrefix(contentType, "application/xhtml") {
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+
+	meta, title := parsePreviewMeta(bytes.NewReader(body))
+	preview := &fetchedPreview{
+		Title:       firstNonEmpty(meta["og:title"], meta["twitter:title"], title),
+		Description: firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"]),
+	}
+
+	if image := firstNonEmpty(meta["og:image"], meta["og:image:url"], meta["twitter:image"], meta["twitter:image:src"]); image != "" {
+		if imageURL, err := page.Parse(image); err == nil {
+			data, contentType, err := previewGet(ctx, client, imageURL.String(), previewMaxImage)
+			if err == nil && strings.HasPrefix(contentType, "image/") {
+				preview.Thumbnail, err = makeThumbnail(data)
+			}
+			if err != nil {
+				log.Debug().Err(err).Str("image", imageURL.String()).Msg("Could not fetch link preview image")
+			}
+		}
+	}
+
+	linkPreviews.Set(link, preview, cache.DefaultExpiration)
+	return preview, nil
+}
+
+// Returns the HTTP client used to fetch previews. Without a proxy, requests
+// to loopback and private addresses are refused.
+func previewClient(proxyURL string) (*http.Client, error) {
+	dialer := &net.Dialer{Timeout: previewTimeout}
+	transport := &http.Transport{
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    previewTimeout,
+		ResponseHeaderTimeout:  previewTimeout,
+		MaxResponseHeaderBytes: 64 * 1024,
+	}
+
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	} else {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				return fmt.Errorf("address %s is not allowed", host)
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   previewTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}, nil
+}
+
+// Downloads up to limit bytes of a URL
+func previewGet(ctx context.Context, client *http.Client, link string, limit int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", previewUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > limit {
+		return nil, "", fmt.Errorf("content too large (%d bytes)", resp.ContentLength)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, strings.ToLower(resp.Header.Get("Content-Type")), nil
+}
+
+// Reads the meta tags and title of the head of a page
+func parsePreviewMeta(r io.Reader) (map[string]string, string) {
+	meta := map[string]string{}
+	title := ""
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return meta, title
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var attr, value []byte
+					attr, value, hasAttr = z.TagAttr()
+					switch strings.ToLower(string(attr)) {
+					case "property", "name":
+						key = strings.ToLower(string(value))
+					case "content":
+						content = strings.TrimSpace(string(value))
+					}
+				}
+				if key != "" && content != "" && meta[key] == "" {
+					meta[key] = content
+				}
+			case "title":
+				if title == "" && z.Next() == html.TextToken {
+					title = strings.TrimSpace(string(z.Text()))
+				}
+			case "body":
+				// Metadata is only read from the head
+				return meta, title
+			}
+		}
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
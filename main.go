@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"testing"
 	"time"
 
 	"go.mau.fi/whatsmeow/store/sqlstore"
@@ -33,14 +34,22 @@ type server struct {
 }
 
 var (
-	address     = flag.String("address", "0.0.0.0", "Bind IP Address")
-	port        = flag.String("port", "8080", "Listen Port")
-	waDebug     = flag.String("wadebug", "", "Enable whatsmeow debug (INFO or DEBUG)")
-	logType     = flag.String("logtype", "console", "Type of log output (console or json)")
-	colorOutput = flag.Bool("color", false, "Enable colored output for console logs")
-	sslcert     = flag.String("sslcertificate", "", "SSL Certificate File")
-	sslprivkey  = flag.String("sslprivatekey", "", "SSL Certificate Private Key File")
-	adminToken  = flag.String("admintoken", "", "Security Token to authorize admin actions (list/create/remove users)")
+	address           = flag.String("address", "0.0.0.0", "Bind IP Address")
+	port              = flag.String("port", "8080", "Listen Port")
+	waDebug           = flag.String("wadebug", "", "Enable whatsmeow debug (INFO or DEBUG)")
+	logType           = flag.String("logtype", "console", "Type of log output (console or json)")
+	colorOutput       = flag.Bool("color", false, "Enable colored output for console logs")
+	sslcert           = flag.String("sslcertificate", "", "SSL Certificate File")
+	sslprivkey        = flag.String("sslprivatekey", "", "SSL Certificate Private Key File")
+	adminToken        = flag.String("admintoken", "", "Security Token to authorize admin actions (list/create/remove users)")
+	queueRate         = flag.Int("queuerate", 20, "Default messages per minute sent by the outbound queue of each instance")
+	queueJitter       = flag.Int("queuejitter", 3000, "Default maximum random delay in milliseconds between queued messages")
+	queueRetries      = flag.Int("queueretries", 5, "Maximum send attempts for queued messages failing with transient errors")
+	scheduledPolicy   = flag.String("scheduledpolicy", "late", "What to do with scheduled messages that could not be sent on time (late or drop)")
+	scheduledGrace    = flag.Int("scheduledgrace", 300, "Seconds a scheduled message may be delayed before it is considered past due")
+	messageRetention  = flag.Int("messageretention", 7, "Days sent and received messages are kept to quote them in replies (0 disables the message store)")
+	simulateTyping    = flag.Bool("simulatetyping", false, "Show typing in the chat before sending messages, unless the instance or the payload says otherwise")
+	idempotencyWindow = flag.Int("idempotencywindow", 86400, "Seconds during which a repeated Idempotency-Key or message Id returns the original response")
 
 	container     *sqlstore.Container
 	killchannel   = make(map[int](chan bool))
@@ -53,7 +62,10 @@ func init() {
 		log.Warn().Err(err).Msg("Não foi possível carregar o arquivo .env (pode ser que não exista).")
 	}
 
-	flag.Parse()
+	// Test binaries parse their own flags after init
+	if !testing.Testing() {
+		flag.Parse()
+	}
 
 	tz := os.Getenv("TZ")
 	if tz != "" {
@@ -105,6 +117,15 @@ func init() {
 			Logger()
 	}
 
+	if *scheduledPolicy != scheduledLate && *scheduledPolicy != scheduledDrop {
+		log.Warn().Str("scheduledpolicy", *scheduledPolicy).Msg("Política de mensagens agendadas inválida, usando late")
+		*scheduledPolicy = scheduledLate
+	}
+
+	if *queueRate < 1 {
+		log.Fatal().Int("queuerate", *queueRate).Msg("O -queuerate deve ser de pelo menos 1 mensagem por minuto")
+	}
+
 	if *adminToken == "" {
 		if v := os.Getenv("WUZAPI_ADMIN_TOKEN"); v != "" {
 			*adminToken = v
@@ -155,6 +176,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := checkAndCreateMissingTables(db); err != nil {
+		log.Fatal().Err(err).Msg("Falha ao criar tabelas auxiliares")
+		os.Exit(1)
+	}
+
+	go purgeIdempotencyKeys(db)
+	go purgeStoredMessages(db)
+	go purgeStatuses(db)
+
 	var dbLog waLog.Logger
 	if *waDebug != "" {
 		dbLog = waLog.Stdout("Database", *waDebug, *colorOutput)
@@ -175,7 +205,7 @@ func main() {
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "token", "instance-token"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "token", "instance-token", "Idempotency-Key"},
 		AllowCredentials: true,
 	})
 
@@ -250,6 +280,11 @@ func checkAndAddMissingColumns(db *sqlx.DB) error {
 	}{
 		{"proxy_url", "TEXT"},
 		{"events", "TEXT NOT NULL DEFAULT 'All'"},
+		{"queue_rate", "INTEGER"},
+		{"queue_jitter", "INTEGER"},
+		{"timezone", "TEXT"},
+		{"scheduled_policy", "TEXT"},
+		{"simulate_typing", "BOOLEAN"},
 	}
 
 	for _, col := range requiredColumns {
@@ -281,6 +316,205 @@ func checkAndAddMissingColumns(db *sqlx.DB) error {
 	return nil
 }
 
+func checkAndCreateMissingTables(db *sqlx.DB) error {
+	log.Info().Msg("Verificando tabelas faltantes...")
+
+	// Lista de tabelas necessárias, criadas somente se ainda não existirem
+	requiredTables := []struct {
+		name       string
+		definition string
+	}{
+		{"message_queue", `
+			CREATE TABLE IF NOT EXISTS message_queue (
+				id SERIAL PRIMARY KEY,
+				job_id TEXT NOT NULL UNIQUE,
+				user_id INTEGER NOT NULL,
+				recipient TEXT NOT NULL,
+				message_id TEXT NOT NULL,
+				message BYTEA NOT NULL,
+				status TEXT NOT NULL DEFAULT 'queued',
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				sent_at TIMESTAMPTZ,
+				send_at TIMESTAMPTZ,
+				simulate_typing BOOLEAN NOT NULL DEFAULT FALSE,
+				next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS message_queue_pending_idx ON message_queue (user_id, status, next_attempt_at);`},
+		{"campaigns", `
+			CREATE TABLE IF NOT EXISTS campaigns (
+				id SERIAL PRIMARY KEY,
+				campaign_id TEXT NOT NULL UNIQUE,
+				user_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				message BYTEA NOT NULL,
+				message_type TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'draft',
+				rate INTEGER NOT NULL,
+				window_start TEXT NOT NULL DEFAULT '',
+				window_end TEXT NOT NULL DEFAULT '',
+				next_send_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				started_at TIMESTAMPTZ,
+				finished_at TIMESTAMPTZ,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS campaigns_user_idx ON campaigns (user_id, status);`},
+		{"campaign_recipients", `
+			CREATE TABLE IF NOT EXISTS campaign_recipients (
+				id SERIAL PRIMARY KEY,
+				campaign_id INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+				phone TEXT NOT NULL,
+				variables TEXT NOT NULL DEFAULT '{}',
+				status TEXT NOT NULL DEFAULT 'pending',
+				message_id TEXT NOT NULL DEFAULT '',
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				sent_at TIMESTAMPTZ,
+				delivered_at TIMESTAMPTZ,
+				read_at TIMESTAMPTZ,
+				UNIQUE (campaign_id, phone)
+			);
+			CREATE INDEX IF NOT EXISTS campaign_recipients_pending_idx ON campaign_recipients (campaign_id, status, next_attempt_at);
+			CREATE INDEX IF NOT EXISTS campaign_recipients_message_idx ON campaign_recipients (message_id);`},
+		{"message_templates", `
+			CREATE TABLE IF NOT EXISTS message_templates (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				version INTEGER NOT NULL,
+				definition TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				UNIQUE (user_id, name, version)
+			);`},
+		{"message_store", `
+			CREATE TABLE IF NOT EXISTS message_store (
+				user_id INTEGER NOT NULL,
+				message_id TEXT NOT NULL,
+				chat TEXT NOT NULL,
+				sender TEXT NOT NULL,
+				from_me BOOLEAN NOT NULL DEFAULT FALSE,
+				message BYTEA NOT NULL,
+				timestamp TIMESTAMPTZ NOT NULL,
+				PRIMARY KEY (user_id, message_id)
+			);
+			CREATE INDEX IF NOT EXISTS message_store_timestamp_idx ON message_store (timestamp);`},
+		{"idempotency_keys", `
+			CREATE TABLE IF NOT EXISTS idempotency_keys (
+				user_id INTEGER NOT NULL,
+				idem_key TEXT NOT NULL,
+				status_code INTEGER,
+				response TEXT NOT NULL DEFAULT '',
+				request_hash TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (user_id, idem_key)
+			);
+			CREATE INDEX IF NOT EXISTS idempotency_keys_created_idx ON idempotency_keys (created_at);`},
+		{"statuses", `
+			CREATE TABLE IF NOT EXISTS statuses (
+				user_id INTEGER NOT NULL,
+				message_id TEXT NOT NULL,
+				sender TEXT NOT NULL,
+				push_name TEXT NOT NULL DEFAULT '',
+				type TEXT NOT NULL,
+				message BYTEA NOT NULL,
+				timestamp TIMESTAMPTZ NOT NULL,
+				seen_at TIMESTAMPTZ,
+				PRIMARY KEY (user_id, message_id)
+			);
+			CREATE INDEX IF NOT EXISTS statuses_timestamp_idx ON statuses (timestamp);`},
+		{"poll_votes", `
+			CREATE TABLE IF NOT EXISTS poll_votes (
+				user_id INTEGER NOT NULL,
+				poll_id TEXT NOT NULL,
+				voter TEXT NOT NULL,
+				selected TEXT[] NOT NULL DEFAULT '{}',
+				timestamp TIMESTAMPTZ NOT NULL,
+				PRIMARY KEY (user_id, poll_id, voter)
+			);`},
+		{"chat_ephemeral", `
+			CREATE TABLE IF NOT EXISTS chat_ephemeral (
+				user_id INTEGER NOT NULL,
+				chat TEXT NOT NULL,
+				timer INTEGER NOT NULL DEFAULT 0,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (user_id, chat)
+			);`},
+		{"group_automations", `
+			CREATE TABLE IF NOT EXISTS group_automations (
+				user_id INTEGER NOT NULL,
+				group_jid TEXT NOT NULL,
+				event TEXT NOT NULL,
+				enabled BOOLEAN NOT NULL DEFAULT TRUE,
+				body TEXT NOT NULL,
+				image TEXT NOT NULL DEFAULT '',
+				video TEXT NOT NULL DEFAULT '',
+				document TEXT NOT NULL DEFAULT '',
+				file_name TEXT NOT NULL DEFAULT '',
+				mention BOOLEAN NOT NULL DEFAULT FALSE,
+				cooldown INTEGER NOT NULL DEFAULT 0,
+				last_sent_at TIMESTAMPTZ,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (user_id, group_jid, event)
+			);`},
+		{"group_moderation", `
+			CREATE TABLE IF NOT EXISTS group_moderation (
+				user_id INTEGER NOT NULL,
+				group_jid TEXT NOT NULL,
+				enabled BOOLEAN NOT NULL DEFAULT TRUE,
+				block_links BOOLEAN NOT NULL DEFAULT FALSE,
+				allowed_domains TEXT[] NOT NULL DEFAULT '{}',
+				banned_words TEXT[] NOT NULL DEFAULT '{}',
+				banned_patterns TEXT[] NOT NULL DEFAULT '{}',
+				flood_messages INTEGER NOT NULL DEFAULT 0,
+				flood_seconds INTEGER NOT NULL DEFAULT 0,
+				block_forwarded BOOLEAN NOT NULL DEFAULT FALSE,
+				revoke BOOLEAN NOT NULL DEFAULT FALSE,
+				warn BOOLEAN NOT NULL DEFAULT FALSE,
+				warning_text TEXT NOT NULL DEFAULT '',
+				max_strikes INTEGER NOT NULL DEFAULT 0,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (user_id, group_jid)
+			);`},
+		{"group_moderation_strikes", `
+			CREATE TABLE IF NOT EXISTS group_moderation_strikes (
+				user_id INTEGER NOT NULL,
+				group_jid TEXT NOT NULL,
+				participant TEXT NOT NULL,
+				strikes INTEGER NOT NULL DEFAULT 0,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (user_id, group_jid, participant)
+			);`},
+		{"group_moderation_log", `
+			CREATE TABLE IF NOT EXISTS group_moderation_log (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				group_jid TEXT NOT NULL,
+				participant TEXT NOT NULL,
+				message_id TEXT NOT NULL,
+				rule TEXT NOT NULL,
+				detail TEXT NOT NULL DEFAULT '',
+				actions TEXT[] NOT NULL DEFAULT '{}',
+				strikes INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS group_moderation_log_user_idx ON group_moderation_log (user_id, created_at);`},
+	}
+
+	for _, table := range requiredTables {
+		if _, err := db.Exec(table.definition); err != nil {
+			log.Error().Err(err).Str("table", table.name).Msg("Erro ao criar tabela")
+			return fmt.Errorf("falha ao criar tabela %s: %w", table.name, err)
+		}
+		log.Debug().Str("table", table.name).Msg("Tabela verificada")
+	}
+
+	return nil
+}
+
 func applyMigrationsAndCreateUser(db *sqlx.DB, exPath string) error {
 	log.Info().Msg("Executando migrações...")
 
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Mentions are written in the text as @5511999999999 or @+5511999999999.
+// WhatsApp only highlights them when the token matches the user part of a
+// JID in MentionedJID, so tokens are normalized and resolved here instead
+// of trusting callers to keep both in sync.
+
+var mentionPattern = regexp.MustCompile(`@\+?(\d+)`)
+
+// Returns the text field of a message that can carry mentions
+func mentionableText(msg *waProto.Message) **string {
+	switch {
+	case msg.ExtendedTextMessage != nil:
+		return &msg.ExtendedTextMessage.Text
+	case msg.ImageMessage != nil:
+		return &msg.ImageMessage.Caption
+	case msg.VideoMessage != nil:
+		return &msg.VideoMessage.Caption
+	case msg.DocumentMessage != nil:
+		return &msg.DocumentMessage.Caption
+	}
+	return nil
+}
+
+// Finds the @number tokens of a text. Returns the text with the optional +
+// removed from them and the mentioned JIDs.
+func parseMentions(text string) (string, []string, error) {
+	var out strings.Builder
+	var mentions []string
+	last := 0
+
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+		number := text[match[2]:match[3]]
+
+		// Skip e-mail addresses and numbers followed by letters
+		if prev, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && (unicode.IsLetter(prev) || unicode.IsDigit(prev)) {
+			continue
+		}
+		if next, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && (unicode.IsLetter(next) || next == '_') {
+			continue
+		}
+
+		// Numbers written with + are always meant as mentions, others only
+		// when they look like a phone number
+		if len(number) < 8 || len(number) > 15 {
+			if strings.HasPrefix(text[start:end], "@+") {
+				return text, nil, fmt.Errorf("Invalid mention %q, phone numbers must have 8 to 15 digits with country code", text[start:end])
+			}
+			continue
+		}
+		out.WriteString(text[last:start])
+		out.WriteString("@" + number)
+		last = end
+		mentions = append(mentions, types.NewJID(number, types.DefaultUserServer).String())
+	}
+	out.WriteString(text[last:])
+	return out.String(), mentions, nil
+}
+
+// Collects the JIDs mentioned by a message: the ones given in Mentions or
+// ContextInfo, the @number tokens of its text and, with MentionAll, every
+// participant of the group
+func (s *server) resolveMentions(ctx context.Context, userid int, recipient types.JID, msg *waProto.Message, t *sendRequest) ([]string, error) {
+	var mentions []string
+	seen := map[string]bool{}
+	add := func(jid string) {
+		if !seen[jid] {
+			seen[jid] = true
+			mentions = append(mentions, jid)
+		}
+	}
+
+	for _, mention := range t.Mentions {
+		if mention == "" {
+			return nil, errors.New("Empty mention in Mentions")
+		}
+		jid, ok := parseJID(mention)
+		if !ok {
+			return nil, fmt.Errorf("Invalid mention %q", mention)
+		}
+		add(jid.String())
+	}
+	for _, jid := range t.ContextInfo.MentionedJID {
+		add(jid)
+	}
+
+	if text := mentionableText(msg); text != nil && *text != nil {
+		normalized, parsed, err := parseMentions(**text)
+		if err != nil {
+			return nil, err
+		}
+		*text = proto.String(normalized)
+		for _, jid := range parsed {
+			add(jid)
+		}
+	}
+
+	if t.MentionAll {
+		if recipient.Server != types.GroupServer {
+			return nil, errors.New("MentionAll is only supported for groups")
+		}
+		info, err := getGroupInfo(ctx, userid, recipient, false)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get group participants: %v", err)
+		}
+		for _, participant := range info.Participants {
+			add(participant.JID.String())
+		}
+	}
+	return mentions, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		text     string
+		want     string
+		mentions []string
+		ok       bool
+	}{
+		{"hello", "hello", nil, true},
+		{"hi @5491155554444!", "hi @5491155554444!", []string{"5491155554444@s.whatsapp.net"}, true},
+		{"hi @+5491155554444", "hi @5491155554444", []string{"5491155554444@s.whatsapp.net"}, true},
+		{"@5491155554444 and @5491155553333", "@5491155554444 and @5491155553333",
+			[]string{"5491155554444@s.whatsapp.net", "5491155553333@s.whatsapp.net"}, true},
+		{"mail john@5491155554444", "mail john@5491155554444", nil, true},
+		{"@5491155554444abc", "@5491155554444abc", nil, true},
+		{"@5491155554444_x", "@5491155554444_x", nil, true},
+		{"room @123", "room @123", nil, true},
+		{"@1234567890123456", "@1234567890123456", nil, true},
+		{"call @+123", "", nil, false},
+	}
+	for _, test := range tests {
+		got, mentions, err := parseMentions(test.text)
+		if (err == nil) != test.ok {
+			t.Errorf("parseMentions(%q) err = %v, want ok %v", test.text, err, test.ok)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseMentions(%q) text = %q, want %q", test.text, got, test.want)
+		}
+		if !reflect.DeepEqual(mentions, test.mentions) {
+			t.Errorf("parseMentions(%q) mentions = %v, want %v", test.text, mentions, test.mentions)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+	"github.com/vincent-petithory/dataurl"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Content of a message of any supported type. Field names match the ones
+// used by the /chat/send/* payloads so the same JSON can be reused.
+type messageContent struct {
+	Type string // text, image, audio, document, video, sticker, location, contact, buttons, list, interactive or poll
+
+	Body    string // text and interactive
+	Caption string // image, video and document
+
+	Image    string // data URLs with the media, image, video and document are also used as buttons and interactive header
+	Audio    string
+	Document string
+	Video    string
+	Sticker  string
+
+	FileName      string // document
+	MimeType      string // document
+	Seconds       uint32 // audio
+	Waveform      []byte // audio
+	JPEGThumbnail []byte // video
+	PngThumbnail  []byte // sticker
+
+	Name      string // location name or contact display name
+	Latitude  float64
+	Longitude float64
+	Vcard     string
+	Contacts  []contactCard // contact, vCards are generated and several contacts are sent together
+
+	Title       string // buttons and list, header of interactive
+	Description string
+	ButtonText  string
+	FooterText  string
+	Buttons     []messageButton
+	Sections    []messageSection
+
+	Header          string // poll question
+	Options         []string
+	SelectableCount *int // poll, how many options a voter can pick, 0 for any number, 1 when not set
+}
+
+type messageButton struct {
+	ButtonId    string
+	ButtonText  string
+	Type        string // interactive: reply (default), url, call or copy
+	URL         string // url
+	PhoneNumber string // call
+	CopyCode    string // copy
+}
+
+type messageRow struct {
+	RowId       string
+	Title       string
+	Description string
+}
+
+type messageSection struct {
+	Title string
+	Rows  []messageRow
+}
+
+// Returned by buildMessage when the media could not be uploaded to WhatsApp,
+// any other error means the content itself is invalid
+type uploadError struct {
+	err error
+}
+
+func (e *uploadError) Error() string {
+	return fmt.Sprintf("Failed to upload file: %v", e.err)
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.err
+}
+
+// Builds the WhatsApp message for the given content, uploading media if needed
+func buildMessage(ctx context.Context, client *whatsmeow.Client, c messageContent) (*waProto.Message, error) {
+	switch strings.ToLower(c.Type) {
+	case "text":
+		return buildTextMessage(c)
+	case "image":
+		return buildImageMessage(ctx, client, c)
+	case "audio":
+		return buildAudioMessage(ctx, client, c)
+	case "document":
+		return buildDocumentMessage(ctx, client, c)
+	case "video":
+		return buildVideoMessage(ctx, client, c)
+	case "sticker":
+		return buildStickerMessage(ctx, client, c)
+	case "location":
+		return buildLocationMessage(c)
+	case "contact":
+		return buildContactMessage(c)
+	case "buttons":
+		return buildButtonsMessage(ctx, client, c)
+	case "list":
+		return buildListMessage(c)
+	case "interactive":
+		return buildInteractiveMessage(ctx, client, c)
+	case "poll":
+		return buildPollMessage(client, c)
+	case "":
+		return nil, errors.New("Missing Type in Payload")
+	default:
+		return nil, fmt.Errorf("Unsupported message Type %q", c.Type)
+	}
+}
+
+func buildTextMessage(c messageContent) (*waProto.Message, error) {
+	if c.Body == "" {
+		return nil, errors.New("Missing Body in Payload")
+	}
+	return &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{
+		Text: proto.String(c.Body),
+	}}, nil
+}
+
+// Decodes a data URL from the payload and uploads it to WhatsApp
+func uploadDataURL(ctx context.Context, client *whatsmeow.Client, field string, value string, prefix string, mediaType whatsmeow.MediaType) (*dataurl.DataURL, whatsmeow.UploadResponse, error) {
+	var uploaded whatsmeow.UploadResponse
+	if value == "" {
+		return nil, uploaded, fmt.Errorf("Missing %s in Payload", field)
+	}
+	if !strings.HasPrefix(value, prefix) {
+		return nil, uploaded, fmt.Errorf("%s data should start with \"%s\"", field, prefix)
+	}
+	dataURL, err := dataurl.DecodeString(value)
+	if err != nil {
+		return nil, uploaded, errors.New("Could not decode base64 encoded data from payload")
+	}
+	uploaded, err = client.Upload(ctx, dataURL.Data, mediaType)
+	if err != nil {
+		return nil, uploaded, &uploadError{err}
+	}
+	return dataURL, uploaded, nil
+}
+
+// Makes the small jpeg preview shown while the full image is downloaded
+func makeThumbnail(data []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode image for thumbnail preparation: %v", err)
+	}
+
+	// resize to width 72 using Lanczos resampling and preserve aspect ratio
+	m := resize.Thumbnail(72, 72, img, resize.Lanczos3)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, m, nil); err != nil {
+		return nil, fmt.Errorf("Failed to encode jpeg: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func buildImageMessage(ctx context.Context, client *whatsmeow.Client, c messageContent) (*waProto.Message, error) {
+	dataURL, uploaded, err := uploadDataURL(ctx, client, "Image", c.Image, "data:image", whatsmeow.MediaImage)
+	if err != nil {
+		return nil, err
+	}
+	thumbnail, err := makeThumbnail(dataURL.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.Message{ImageMessage: &waProto.ImageMessage{
+		Caption:       proto.String(c.Caption),
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String(http.DetectContentType(dataURL.Data)),
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(dataURL.Data))),
+		JPEGThumbnail: thumbnail,
+	}}, nil
+}
+
+func buildAudioMessage(ctx context.Context, client *whatsmeow.Client, c messageContent) (*waProto.Message, error) {
+	dataURL, uploaded, err := uploadDataURL(ctx, client, "Audio", c.Audio, "data:audio/ogg", whatsmeow.MediaAudio)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.Message{AudioMessage: &waProto.AudioMessage{
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String("audio/ogg; codecs=opus"),
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(dataURL.Data))),
+		PTT:           proto.Bool(true),
+		Seconds:       proto.Uint32(c.Seconds),
+		Waveform:      c.Waveform,
+	}}, nil
+}
+
+func buildDocumentMessage(ctx context.Context, client *whatsmeow.Client, c messageContent) (*waProto.Message, error) {
+	if c.FileName == "" {
+		return nil, errors.New("Missing FileName in Payload")
+	}
+	dataURL, uploaded, err := uploadDataURL(ctx, client, "Document", c.Document, "data:", whatsmeow.MediaDocument)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := c.MimeType
+	if mimeType == "" {
+		mimeType = dataURL.MediaType.ContentType()
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(dataURL.Data)
+	}
+	return &waProto.Message{DocumentMessage: &waProto.DocumentMessage{
+		URL:           proto.String(uploaded.URL),
+		FileName:      proto.String(c.FileName),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String(mimeType),
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(dataURL.Data))),
+		Caption:       proto.String(c.Caption),
+	}}, nil
+}
+
+func buildVideoMessage(ctx context.Context, client *whatsmeow.Client, c messageContent) (*waProto.Message, error) {
+	dataURL, uploaded, err := uploadDataURL(ctx, client, "Video", c.Video, "data:", whatsmeow.MediaVideo)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.Message{VideoMessage: &waProto.VideoMessage{
+		Caption:       proto.String(c.Caption),
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String(http.DetectContentType(dataURL.Data)),
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(dataURL.Data))),
+		JPEGThumbnail: c.JPEGThumbnail,
+	}}, nil
+}
+
+func buildStickerMessage(ctx context.Context, client *whatsmeow.Client, c messageContent) (*waProto.Message, error) {
+	dataURL, uploaded, err := uploadDataURL(ctx, client, "Sticker", c.Sticker, "data:", whatsmeow.MediaImage)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.Message{StickerMessage: &waProto.StickerMessage{
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		Mimetype:      proto.String(http.DetectContentType(dataURL.Data)),
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uint64(len(dataURL.Data))),
+		PngThumbnail:  c.PngThumbnail,
+	}}, nil
+}
+
+func buildLocationMessage(c messageContent) (*waProto.Message, error) {
+	if c.Latitude == 0 {
+		return nil, errors.New("Missing Latitude in Payload")
+	}
+	if c.Longitude == 0 {
+		return nil, errors.New("Missing Longitude in Payload")
+	}
+	return &waProto.Message{LocationMessage: &waProto.LocationMessage{
+		DegreesLatitude:  proto.Float64(c.Latitude),
+		DegreesLongitude: proto.Float64(c.Longitude),
+		Name:             proto.String(c.Name),
+	}}, nil
+}
+
+func buildContactMessage(c messageContent) (*waProto.Message, error) {
+	if len(c.Contacts) > 0 {
+		return buildContactsMessage(c.Contacts)
+	}
+	if c.Name == "" {
+		return nil, errors.New("Missing Name in Payload")
+	}
+	if c.Vcard == "" {
+		return nil, errors.New("Missing Vcard or Contacts in Payload")
+	}
+	return &waProto.Message{ContactMessage: &waProto.ContactMessage{
+		DisplayName: proto.String(c.Name),
+		Vcard:       proto.String(c.Vcard),
+	}}, nil
+}
+
+func buildButtonsMessage(ctx context.Context, client *whatsmeow.Client, c messageContent) (*waProto.Message, error) {
+	if c.Title == "" {
+		return nil, errors.New("Missing Title in Payload")
+	}
+	if len(c.Buttons) < 1 {
+		return nil, errors.New("Missing Buttons in Payload")
+	}
+	if len(c.Buttons) > 3 {
+		return nil, errors.New("Buttons cant more than 3")
+	}
+
+	var buttons []*waProto.ButtonsMessage_Button
+	for _, item := range c.Buttons {
+		buttons = append(buttons, &waProto.ButtonsMessage_Button{
+			ButtonID:       proto.String(item.ButtonId),
+			ButtonText:     &waProto.ButtonsMessage_Button_ButtonText{DisplayText: proto.String(item.ButtonText)},
+			Type:           waProto.ButtonsMessage_Button_RESPONSE.Enum(),
+			NativeFlowInfo: &waProto.ButtonsMessage_Button_NativeFlowInfo{},
+		})
+	}
+
+	buttonsMessage := &waProto.ButtonsMessage{
+		ContentText: proto.String(c.Title),
+		HeaderType:  waProto.ButtonsMessage_EMPTY.Enum(),
+		Buttons:     buttons,
+	}
+	if c.FooterText != "" {
+		buttonsMessage.FooterText = proto.String(c.FooterText)
+	}
+
+	// An image, video or document can be shown above the text
+	switch {
+	case c.Image != "":
+		header, err := buildImageMessage(ctx, client, messageContent{Image: c.Image})
+		if err != nil {
+			return nil, err
+		}
+		buttonsMessage.HeaderType = waProto.ButtonsMessage_IMAGE.Enum()
+		buttonsMessage.Header = &waProto.ButtonsMessage_ImageMessage{ImageMessage: header.ImageMessage}
+	case c.Video != "":
+		header, err := buildVideoMessage(ctx, client, messageContent{Video: c.Video})
+		if err != nil {
+			return nil, err
+		}
+		buttonsMessage.HeaderType = waProto.ButtonsMessage_VIDEO.Enum()
+		buttonsMessage.Header = &waProto.ButtonsMessage_VideoMessage{VideoMessage: header.VideoMessage}
+	case c.Document != "":
+		header, err := buildDocumentMessage(ctx, client, messageContent{Document: c.Document, FileName: c.FileName, MimeType: c.MimeType})
+		if err != nil {
+			return nil, err
+		}
+		buttonsMessage.HeaderType = waProto.ButtonsMessage_DOCUMENT.Enum()
+		buttonsMessage.Header = &waProto.ButtonsMessage_DocumentMessage{DocumentMessage: header.DocumentMessage}
+	}
+
+	return &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{
+		Message: &waProto.Message{
+			ButtonsMessage: buttonsMessage,
+		},
+	}}, nil
+}
+
+func buildListMessage(c messageContent) (*waProto.Message, error) {
+	if c.Title == "" {
+		return nil, errors.New("Missing Title in Payload")
+	}
+	if c.Description == "" {
+		return nil, errors.New("Missing Description in Payload")
+	}
+	if c.ButtonText == "" {
+		return nil, errors.New("Missing ButtonText in Payload")
+	}
+	if len(c.Sections) < 1 {
+		return nil, errors.New("Missing Sections in Payload")
+	}
+
+	var sections []*waProto.ListMessage_Section
+	id := 1
+	for _, item := range c.Sections {
+		var rows []*waProto.ListMessage_Row
+		for _, row := range item.Rows {
+			idtext := row.RowId
+			if idtext == "" {
+				idtext = strconv.Itoa(id)
+			}
+			id++
+			rows = append(rows, &waProto.ListMessage_Row{
+				RowID:       proto.String(idtext),
+				Title:       proto.String(row.Title),
+				Description: proto.String(row.Description),
+			})
+		}
+		sections = append(sections, &waProto.ListMessage_Section{
+			Title: proto.String(item.Title),
+			Rows:  rows,
+		})
+	}
+
+	return &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{
+		Message: &waProto.Message{
+			ListMessage: &waProto.ListMessage{
+				Title:       proto.String(c.Title),
+				Description: proto.String(c.Description),
+				ButtonText:  proto.String(c.ButtonText),
+				ListType:    waProto.ListMessage_SINGLE_SELECT.Enum(),
+				Sections:    sections,
+				FooterText:  proto.String(c.FooterText),
+			},
+		},
+	}}, nil
+}
+
+func buildPollMessage(client *whatsmeow.Client, c messageContent) (*waProto.Message, error) {
+	if c.Header == "" {
+		return nil, errors.New("Missing Header in Payload")
+	}
+	if len(c.Options) < 2 {
+		return nil, errors.New("At least 2 options are required")
+	}
+	selectable := 1
+	if c.SelectableCount != nil {
+		selectable = *c.SelectableCount
+	}
+	if selectable < 0 || selectable > len(c.Options) {
+		return nil, fmt.Errorf("SelectableCount must be between 0 and %d", len(c.Options))
+	}
+	return client.BuildPollCreation(c.Header, c.Options, selectable), nil
+}
+
+var variablePattern = regexp.MustCompile(`\{\{\s*([\w.-]+)\s*\}\}`)
+
+// Replaces {{name}} placeholders with the given variables, unknown names render empty
+func renderVariables(text string, vars map[string]string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	return variablePattern.ReplaceAllStringFunc(text, func(match string) string {
+		return vars[variablePattern.FindStringSubmatch(match)[1]]
+	})
+}
+
+// Replaces {{name}} placeholders in every text field of a message
+func renderMessageVariables(msg proto.Message, vars map[string]string) {
+	renderFields(msg.ProtoReflect(), vars)
+}
+
+func renderFields(m protoreflect.Message, vars map[string]string) {
+	type update struct {
+		fd    protoreflect.FieldDescriptor
+		value protoreflect.Value
+	}
+	var updates []update
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				if fd.Kind() == protoreflect.MessageKind {
+					renderFields(list.Get(i).Message(), vars)
+				} else if fd.Kind() == protoreflect.StringKind {
+					list.Set(i, protoreflect.ValueOfString(renderVariables(list.Get(i).String(), vars)))
+				}
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			renderFields(v.Message(), vars)
+		case fd.Kind() == protoreflect.StringKind:
+			if rendered := renderVariables(v.String(), vars); rendered != v.String() {
+				updates = append(updates, update{fd, protoreflect.ValueOfString(rendered)})
+			}
+		}
+		return true
+	})
+
+	for _, u := range updates {
+		m.Set(u.fd, u.value)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestRenderVariables(t *testing.T) {
+	vars := map[string]string{"name": "John", "order.id": "42"}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Hello", "Hello"},
+		{"Hello {{name}}", "Hello John"},
+		{"Hello {{ name }}!", "Hello John!"},
+		{"Order {{order.id}} for {{name}}", "Order 42 for John"},
+		{"Hi {{unknown}}.", "Hi ."},
+		{"Hi {name}", "Hi {name}"},
+	}
+	for _, test := range tests {
+		if got := renderVariables(test.text, vars); got != test.want {
+			t.Errorf("renderVariables(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
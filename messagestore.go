@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Sent and received messages are kept for a while so replies can carry the
+// real content of the quoted message. Recent messages are also held in
+// memory, which keeps quoting working when the store is disabled with
+// -messageretention 0.
+
+const storePurgeInterval = time.Hour
+
+var recentMessages = cache.New(30*time.Minute, 10*time.Minute)
+
+type storedMessage struct {
+	ID        string
+	Chat      types.JID
+	Sender    types.JID
+	FromMe    bool
+	Timestamp time.Time
+	Message   *waProto.Message
+}
+
+func recentMessageKey(userID int, id string) string {
+	return strconv.Itoa(userID) + ":" + id
+}
+
+// Keeps a message for quoting
+func storeMessage(db *sqlx.DB, userID int, m *storedMessage) {
+	recentMessages.Set(recentMessageKey(userID, m.ID), m, cache.DefaultExpiration)
+
+	if *messageRetention <= 0 {
+		return
+	}
+	data, err := proto.Marshal(m.Message)
+	if err != nil {
+		log.Error().Err(err).Str("id", m.ID).Msg("Could not encode message to store")
+		return
+	}
+	_, err = db.Exec(`INSERT INTO message_store (user_id, message_id, chat, sender, from_me, message, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (user_id, message_id) DO NOTHING`,
+		userID, m.ID, m.Chat.String(), m.Sender.String(), m.FromMe, data, m.Timestamp)
+	if err != nil {
+		log.Error().Err(err).Str("id", m.ID).Msg("Could not store message")
+	}
+}
+
+// Keeps a message sent by the instance
+func storeSentMessage(db *sqlx.DB, userID int, client *whatsmeow.Client, chat types.JID, id string, msg *waProto.Message, timestamp time.Time) {
+	var sender types.JID
+	if client.Store.ID != nil {
+		sender = client.Store.ID.ToNonAD()
+	}
+	storeMessage(db, userID, &storedMessage{
+		ID:        id,
+		Chat:      chat,
+		Sender:    sender,
+		FromMe:    true,
+		Timestamp: timestamp,
+		Message:   msg,
+	})
+}
+
+// Keeps a received message, protocol messages like reactions and edits are skipped
+func storeReceivedMessage(db *sqlx.DB, userID int, evt *events.Message) {
+	if evt.Message == nil || evt.Message.ProtocolMessage != nil || evt.Message.ReactionMessage != nil || evt.Message.PollUpdateMessage != nil {
+		return
+	}
+	storeMessage(db, userID, &storedMessage{
+		ID:        evt.Info.ID,
+		Chat:      evt.Info.Chat,
+		Sender:    evt.Info.Sender.ToNonAD(),
+		FromMe:    evt.Info.IsFromMe,
+		Timestamp: evt.Info.Timestamp,
+		Message:   evt.Message,
+	})
+}
+
+// Finds a message by id, returns sql.ErrNoRows when it is not known
+func loadStoredMessage(db *sqlx.DB, userID int, id string) (*storedMessage, error) {
+	if cached, found := recentMessages.Get(recentMessageKey(userID, id)); found {
+		return cached.(*storedMessage), nil
+	}
+	if *messageRetention <= 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	var row struct {
+		Chat      string    `db:"chat"`
+		Sender    string    `db:"sender"`
+		FromMe    bool      `db:"from_me"`
+		Message   []byte    `db:"message"`
+		Timestamp time.Time `db:"timestamp"`
+	}
+	err := db.Get(&row, `SELECT chat, sender, from_me, message, timestamp FROM message_store WHERE user_id=$1 AND message_id=$2`, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &storedMessage{ID: id, FromMe: row.FromMe, Timestamp: row.Timestamp, Message: &waProto.Message{}}
+	if err := proto.Unmarshal(row.Message, m.Message); err != nil {
+		return nil, err
+	}
+	if m.Chat, err = types.ParseJID(row.Chat); err != nil {
+		return nil, err
+	}
+	if m.Sender, err = types.ParseJID(row.Sender); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Returns the copy of a stored message embedded in replies, without its own
+// quote and encryption secrets
+func quotedContent(msg *waProto.Message) *waProto.Message {
+	quoted := proto.Clone(msg).(*waProto.Message)
+	quoted.MessageContextInfo = nil
+	if contextInfo := contextInfoOf(quoted); contextInfo != nil {
+		contextInfo.StanzaID = nil
+		contextInfo.Participant = nil
+		contextInfo.RemoteJID = nil
+		contextInfo.QuotedMessage = nil
+	}
+	return quoted
+}
+
+// Removes messages older than the retention from time to time
+func purgeStoredMessages(db *sqlx.DB) {
+	if *messageRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(storePurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_, err := db.Exec("DELETE FROM message_store WHERE timestamp < NOW() - make_interval(days => $1)", *messageRetention)
+		if err != nil {
+			log.Error().Err(err).Msg("Could not purge stored messages")
+		}
+		_, err = db.Exec("DELETE FROM poll_votes WHERE timestamp < NOW() - make_interval(days => $1)", *messageRetention)
+		if err != nil {
+			log.Error().Err(err).Msg("Could not purge poll votes")
+		}
+	}
+}
@@ -9,5 +9,10 @@ CREATE TABLE IF NOT EXISTS users (
     connected INTEGER,
     expiration INTEGER,
     proxy_url TEXT,
-    events TEXT NOT NULL DEFAULT 'All'
+    events TEXT NOT NULL DEFAULT 'All',
+    queue_rate INTEGER,
+    queue_jitter INTEGER,
+    timezone TEXT,
+    scheduled_policy TEXT,
+    simulate_typing BOOLEAN
 );
\ No newline at end of file
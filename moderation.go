@@ -0,0 +1,687 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Moderation rules are checked on every message received in a group where
+// the instance is admin. Messages of admins are never checked. A message
+// breaking a rule can be revoked, its sender warned, and every violation
+// counts as a strike, removing the sender once the group limit is reached.
+// Every violation is logged in group_moderation_log and posted with the
+// GroupModeration webhook.
+
+const (
+	moderationCacheTTL      = 5 * time.Minute
+	moderationActionTimeout = 30 * time.Second
+	moderationLogLimit      = 100
+	moderationLogMaxLimit   = 1000
+
+	// WhatsApp shows "Forwarded many times" from this forwarding score on
+	frequentlyForwardedScore = 5
+
+	defaultWarningText = "{{name}}, your message broke the rules of this group ({{rule}})."
+)
+
+var (
+	// Rules of the groups, nil when a group has none, so messages do not
+	// hit the database
+	moderationCache = cache.New(moderationCacheTTL, 10*time.Minute)
+
+	// Recent message times of every participant, for the flood rule
+	floodCache = cache.New(time.Minute, 10*time.Minute)
+	floodMutex sync.Mutex
+
+	// Links start with http(s):// or www., or are a bare domain with one
+	// of the linkTLDs, so file names and abbreviations are not links
+	linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)?((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,24})\b(?:[:/?#]\S*)?`)
+
+	// Domains of bare links, the common generic ones and the country ones
+	// mostly used by link shorteners. They can be followed by a country
+	// domain, like example.com.br.
+	linkTLDs = map[string]bool{
+		"com": true, "net": true, "org": true, "info": true, "biz": true, "xyz": true, "online": true,
+		"site": true, "website": true, "store": true, "shop": true, "app": true, "dev": true, "link": true,
+		"live": true, "club": true, "top": true, "vip": true, "click": true, "io": true, "co": true,
+		"me": true, "ly": true, "gl": true, "gg": true, "tv": true, "cc": true, "ws": true, "to": true,
+	}
+)
+
+type moderationRules struct {
+	GroupJID       string         `db:"group_jid"`
+	Enabled        bool           `db:"enabled"`
+	BlockLinks     bool           `db:"block_links"`
+	AllowedDomains pq.StringArray `db:"allowed_domains"` // links to these domains and their subdomains are allowed
+	BannedWords    pq.StringArray `db:"banned_words"`    // matched as whole words, ignoring case
+	BannedPatterns pq.StringArray `db:"banned_patterns"` // regular expressions
+	FloodMessages  int            `db:"flood_messages"`  // more messages than this within FloodSeconds is flooding
+	FloodSeconds   int            `db:"flood_seconds"`
+	BlockForwarded bool           `db:"block_forwarded"` // content forwarded many times
+	Revoke         bool           `db:"revoke"`          // delete the message for everyone
+	Warn           bool           `db:"warn"`            // reply to the message with the warning
+	WarningText    string         `db:"warning_text"`    // {{name}}, {{rule}}, {{strikes}} and {{maxStrikes}}
+	MaxStrikes     int            `db:"max_strikes"`     // remove the participant at this many strikes, 0 never
+	UpdatedAt      time.Time      `db:"updated_at"`
+
+	words    *regexp.Regexp
+	patterns []*regexp.Regexp
+}
+
+const moderationColumns = `group_jid, enabled, block_links, allowed_domains, banned_words, banned_patterns,
+	flood_messages, flood_seconds, block_forwarded, revoke, warn, warning_text, max_strikes, updated_at`
+
+// Posted with the GroupModeration webhook and kept in the moderation log
+type moderationAction struct {
+	GroupJID    string         `db:"group_jid"`
+	Participant string         `db:"participant"`
+	MessageID   string         `db:"message_id"`
+	Rule        string         `db:"rule"`    // link, banned_word, banned_pattern, flood or forwarded
+	Detail      string         `db:"detail"`  // what matched
+	Actions     pq.StringArray `db:"actions"` // revoke, warn and remove, the ones that succeeded
+	Strikes     int            `db:"strikes"` // strikes of the participant after this violation
+	Errors      []string       `db:"-" json:",omitempty"`
+	Timestamp   time.Time      `db:"created_at"`
+}
+
+// Checks the rules and prepares them to be evaluated
+func (rules *moderationRules) compile() error {
+	if rules.FloodMessages < 0 || rules.FloodSeconds < 0 {
+		return errors.New("FloodMessages and FloodSeconds cannot be negative")
+	}
+	if (rules.FloodMessages > 0) != (rules.FloodSeconds > 0) {
+		return errors.New("FloodMessages and FloodSeconds must be set together")
+	}
+	if rules.MaxStrikes < 0 {
+		return errors.New("MaxStrikes cannot be negative")
+	}
+
+	for i, domain := range rules.AllowedDomains {
+		rules.AllowedDomains[i] = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "www.")
+	}
+
+	var words []string
+	for _, word := range rules.BannedWords {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, regexp.QuoteMeta(word))
+		}
+	}
+	rules.words = nil
+	if len(words) > 0 {
+		// \b only knows ASCII letters, so words are delimited by hand
+		rules.words = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}])(` + strings.Join(words, "|") + `)(?:$|[^\p{L}\p{N}])`)
+	}
+
+	rules.patterns = nil
+	for _, pattern := range rules.BannedPatterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid BannedPatterns %q: %v", pattern, err)
+		}
+		rules.patterns = append(rules.patterns, compiled)
+	}
+	return nil
+}
+
+func (rules *moderationRules) allowedDomain(host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range rules.AllowedDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a domain found without http(s):// or www. is a link, it
+// is not when its TLD is not one of the linkTLDs or it is part of an email
+// address
+func isBareLink(text string, start int, host string) bool {
+	if start > 0 && text[start-1] == '@' {
+		return false
+	}
+	labels := strings.Split(strings.ToLower(host), ".")
+	for _, label := range labels[1:] {
+		if linkTLDs[label] {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the rule a message breaks and what matched, or an empty rule
+func (rules *moderationRules) check(text string, contextInfo *waProto.ContextInfo) (string, string) {
+	if rules.BlockForwarded && contextInfo.GetForwardingScore() >= frequentlyForwardedScore {
+		return "forwarded", fmt.Sprintf("forwarded %d times", contextInfo.GetForwardingScore())
+	}
+	if rules.BlockLinks {
+		for _, match := range linkPattern.FindAllStringSubmatchIndex(text, -1) {
+			link, host := text[match[0]:match[1]], text[match[4]:match[5]]
+			if match[2] < 0 && !isBareLink(text, match[0], host) {
+				continue
+			}
+			if !rules.allowedDomain(host) {
+				return "link", link
+			}
+		}
+	}
+	if rules.words != nil {
+		if match := rules.words.FindStringSubmatch(text); match != nil {
+			return "banned_word", match[1]
+		}
+	}
+	for _, pattern := range rules.patterns {
+		if pattern.MatchString(text) {
+			return "banned_pattern", pattern.String()
+		}
+	}
+	return "", ""
+}
+
+// Returns the moderation rules of a group, nil when it has none
+func loadModerationRules(db *sqlx.DB, userID int, group types.JID) (*moderationRules, error) {
+	key := groupCacheKey(userID, group)
+	if cached, found := moderationCache.Get(key); found {
+		return cached.(*moderationRules), nil
+	}
+
+	rules := &moderationRules{}
+	err := db.Get(rules, `SELECT `+moderationColumns+` FROM group_moderation WHERE user_id=$1 AND group_jid=$2`,
+		userID, group.ToNonAD().String())
+	if errors.Is(err, sql.ErrNoRows) {
+		rules = nil
+	} else if err != nil {
+		return nil, err
+	} else if err := rules.compile(); err != nil {
+		return nil, err
+	}
+	moderationCache.Set(key, rules, cache.DefaultExpiration)
+	return rules, nil
+}
+
+// Counts a message for the flood rule. Returns true when the participant
+// sent more messages than allowed, their count then starts over so a burst
+// is a single violation.
+func isFlooding(userID int, group types.JID, sender types.JID, rules *moderationRules, now time.Time) bool {
+	if rules.FloodMessages == 0 {
+		return false
+	}
+	window := time.Duration(rules.FloodSeconds) * time.Second
+	key := groupCacheKey(userID, group) + ":" + sender.ToNonAD().String()
+
+	floodMutex.Lock()
+	defer floodMutex.Unlock()
+
+	var recent []time.Time
+	if cached, found := floodCache.Get(key); found {
+		for _, sent := range cached.([]time.Time) {
+			if now.Sub(sent) < window {
+				recent = append(recent, sent)
+			}
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) > rules.FloodMessages {
+		floodCache.Delete(key)
+		return true
+	}
+	floodCache.Set(key, recent, window)
+	return false
+}
+
+// Checks a received group message against the moderation rules of the group
+func (mycli *MyClient) moderateGroupMessage(evt *events.Message) {
+	if evt.Info.IsFromMe || evt.Message == nil || evt.Info.Chat.Server != types.GroupServer {
+		return
+	}
+	msg := evt.Message
+	if msg.GetProtocolMessage() != nil || msg.GetReactionMessage() != nil || msg.GetPollUpdateMessage() != nil {
+		return
+	}
+
+	rules, err := loadModerationRules(mycli.db, mycli.userID, evt.Info.Chat)
+	if err != nil {
+		log.Error().Err(err).Str("group", evt.Info.Chat.String()).Msg("Could not load moderation rules")
+		return
+	}
+	if rules == nil || !rules.Enabled {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), moderationActionTimeout)
+	defer cancel()
+	group, err := getGroupInfo(ctx, mycli.userID, evt.Info.Chat, false)
+	if err != nil {
+		log.Warn().Err(err).Str("group", evt.Info.Chat.String()).Msg("Could not get group info for moderation")
+		return
+	}
+	if isGroupAdmin(group, evt.Info.Sender, evt.Info.SenderAlt) {
+		return
+	}
+	if !isGroupAdmin(group, mycli.WAClient.Store.GetJID(), mycli.WAClient.Store.GetLID()) {
+		log.Debug().Str("group", evt.Info.Chat.String()).Msg("Skipping moderation, the instance is not admin of the group")
+		return
+	}
+
+	_, text, _, contextInfo := describeContent(msg)
+	rule, detail := rules.check(text, contextInfo)
+	if isFlooding(mycli.userID, evt.Info.Chat, evt.Info.Sender, rules, time.Now()) && rule == "" {
+		rule, detail = "flood", fmt.Sprintf("more than %d messages in %d seconds", rules.FloodMessages, rules.FloodSeconds)
+	}
+	if rule == "" {
+		return
+	}
+	mycli.enforceModeration(ctx, rules, evt, rule, detail)
+}
+
+// Applies the actions of the rules to a message breaking them
+func (mycli *MyClient) enforceModeration(ctx context.Context, rules *moderationRules, evt *events.Message, rule string, detail string) {
+	client := mycli.WAClient
+	chat := evt.Info.Chat
+	sender := evt.Info.Sender.ToNonAD()
+	action := moderationAction{
+		GroupJID:    chat.String(),
+		Participant: sender.String(),
+		MessageID:   evt.Info.ID,
+		Rule:        rule,
+		Detail:      detail,
+		Actions:     pq.StringArray{},
+		Timestamp:   time.Now(),
+	}
+	failed := func(name string, err error) {
+		log.Error().Err(err).Str("group", chat.String()).Str("participant", sender.String()).Str("action", name).Msg("Moderation action failed")
+		action.Errors = append(action.Errors, fmt.Sprintf("%s: %v", name, err))
+	}
+
+	err := mycli.db.Get(&action.Strikes, `INSERT INTO group_moderation_strikes (user_id, group_jid, participant, strikes, updated_at)
+		VALUES ($1, $2, $3, 1, NOW())
+		ON CONFLICT (user_id, group_jid, participant) DO UPDATE SET strikes=group_moderation_strikes.strikes+1, updated_at=NOW()
+		RETURNING strikes`, mycli.userID, chat.String(), sender.String())
+	if err != nil {
+		failed("strike", err)
+	}
+
+	if rules.Revoke {
+		if _, err := client.SendMessage(ctx, chat, client.BuildRevoke(chat, evt.Info.Sender, evt.Info.ID)); err != nil {
+			failed("revoke", err)
+		} else {
+			action.Actions = append(action.Actions, "revoke")
+		}
+	}
+
+	removed := false
+	if rules.MaxStrikes > 0 && action.Strikes >= rules.MaxStrikes {
+		if _, err := client.UpdateGroupParticipants(ctx, chat, []types.JID{sender}, whatsmeow.ParticipantChangeRemove); err != nil {
+			failed("remove", err)
+		} else {
+			removed = true
+			action.Actions = append(action.Actions, "remove")
+			if _, err := mycli.db.Exec(`DELETE FROM group_moderation_strikes WHERE user_id=$1 AND group_jid=$2 AND participant=$3`,
+				mycli.userID, chat.String(), sender.String()); err != nil {
+				log.Warn().Err(err).Str("participant", sender.String()).Msg("Could not reset moderation strikes")
+			}
+		}
+	}
+
+	if rules.Warn && !removed {
+		if err := mycli.sendModerationWarning(ctx, rules, evt, action); err != nil {
+			failed("warn", err)
+		} else {
+			action.Actions = append(action.Actions, "warn")
+		}
+	}
+
+	log.Info().Str("group", chat.String()).Str("participant", sender.String()).Str("id", evt.Info.ID).Str("rule", rule).Str("detail", detail).
+		Strs("actions", action.Actions).Int("strikes", action.Strikes).Msg("Group moderation")
+	_, err = mycli.db.Exec(`INSERT INTO group_moderation_log (user_id, group_jid, participant, message_id, rule, detail, actions, strikes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		mycli.userID, action.GroupJID, action.Participant, action.MessageID, rule, detail, action.Actions, action.Strikes, action.Timestamp)
+	if err != nil {
+		log.Error().Err(err).Str("group", chat.String()).Msg("Could not log moderation action")
+	}
+	sendEventWebhook(mycli.userID, mycli.token, "GroupModeration", action)
+}
+
+// Replies to the offending message mentioning its sender
+func (mycli *MyClient) sendModerationWarning(ctx context.Context, rules *moderationRules, evt *events.Message, action moderationAction) error {
+	client := mycli.WAClient
+	sender := evt.Info.Sender.ToNonAD()
+	// Mention the phone number, a LID would show as a meaningless number
+	mentioned := sender
+	if mentioned.Server == types.HiddenUserServer {
+		if !evt.Info.SenderAlt.IsEmpty() && evt.Info.SenderAlt.Server == types.DefaultUserServer {
+			mentioned = evt.Info.SenderAlt.ToNonAD()
+		} else if pn, err := client.Store.LIDs.GetPNForLID(ctx, sender); err == nil && !pn.IsEmpty() {
+			mentioned = pn.ToNonAD()
+		}
+	}
+
+	text := rules.WarningText
+	if text == "" {
+		text = defaultWarningText
+	}
+	text = renderVariables(text, map[string]string{
+		"name":       "@" + mentioned.User,
+		"rule":       action.Rule,
+		"strikes":    strconv.Itoa(action.Strikes),
+		"maxStrikes": strconv.Itoa(rules.MaxStrikes),
+	})
+
+	contextInfo := &waProto.ContextInfo{
+		StanzaID:      proto.String(evt.Info.ID),
+		Participant:   proto.String(sender.String()),
+		QuotedMessage: evt.Message,
+		MentionedJID:  []string{mentioned.String()},
+	}
+	if timer, ok := getEphemeralTimer(mycli.db, mycli.userID, evt.Info.Chat); ok && timer > 0 {
+		contextInfo.Expiration = proto.Uint32(timer)
+	}
+	msg := &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String(text), ContextInfo: contextInfo}}
+
+	msgid := whatsmeow.GenerateMessageID()
+	resp, err := client.SendMessage(ctx, evt.Info.Chat, msg, sendRequestExtra(msg, msgid))
+	if err != nil {
+		return err
+	}
+	storeSentMessage(mycli.db, mycli.userID, client, evt.Info.Chat, msgid, msg, resp.Timestamp)
+	return nil
+}
+
+// Reads the optional groupJID query parameter, returns an empty string
+// when it is not given
+func groupJIDParam(r *http.Request) (string, error) {
+	groupJID := r.URL.Query().Get("groupJID")
+	if groupJID == "" {
+		return "", nil
+	}
+	group, ok := parseJID(groupJID)
+	if !ok {
+		return "", errors.New("Could not parse Group JID")
+	}
+	return group.ToNonAD().String(), nil
+}
+
+// Lists the moderation rules of a group, or of all groups when no group is
+// given
+func (s *server) ListGroupModeration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		groupJID, err := groupJIDParam(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		rules := []moderationRules{}
+		err = s.db.Select(&rules, `SELECT `+moderationColumns+` FROM group_moderation
+			WHERE user_id=$1 AND ($2 = '' OR group_jid=$2) ORDER BY group_jid`, userid, groupJID)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list moderation rules: %v", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(rules)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Creates or replaces the moderation rules of a group
+func (s *server) SetGroupModeration() http.HandlerFunc {
+
+	type setGroupModerationStruct struct {
+		moderationRules
+		Enabled *bool // enabled when not given
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupModerationStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		rules := t.moderationRules
+		if rules.GroupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing GroupJID in Payload"))
+			return
+		}
+		group, ok := parseJID(rules.GroupJID)
+		if !ok || group.Server != types.GroupServer {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Group JID"))
+			return
+		}
+		rules.GroupJID = group.ToNonAD().String()
+		rules.Enabled = t.Enabled == nil || *t.Enabled
+		if err := rules.compile(); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		for _, list := range []*pq.StringArray{&rules.AllowedDomains, &rules.BannedWords, &rules.BannedPatterns} {
+			if *list == nil {
+				*list = pq.StringArray{}
+			}
+		}
+
+		err = s.db.Get(&rules, `INSERT INTO group_moderation (user_id, group_jid, enabled, block_links, allowed_domains,
+			banned_words, banned_patterns, flood_messages, flood_seconds, block_forwarded, revoke, warn, warning_text, max_strikes, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NOW())
+			ON CONFLICT (user_id, group_jid) DO UPDATE SET enabled=EXCLUDED.enabled, block_links=EXCLUDED.block_links,
+			allowed_domains=EXCLUDED.allowed_domains, banned_words=EXCLUDED.banned_words, banned_patterns=EXCLUDED.banned_patterns,
+			flood_messages=EXCLUDED.flood_messages, flood_seconds=EXCLUDED.flood_seconds, block_forwarded=EXCLUDED.block_forwarded,
+			revoke=EXCLUDED.revoke, warn=EXCLUDED.warn, warning_text=EXCLUDED.warning_text, max_strikes=EXCLUDED.max_strikes,
+			updated_at=EXCLUDED.updated_at
+			RETURNING `+moderationColumns,
+			userid, rules.GroupJID, rules.Enabled, rules.BlockLinks, rules.AllowedDomains, rules.BannedWords, rules.BannedPatterns,
+			rules.FloodMessages, rules.FloodSeconds, rules.BlockForwarded, rules.Revoke, rules.Warn, rules.WarningText, rules.MaxStrikes)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not save moderation rules: %v", err)))
+			return
+		}
+		moderationCache.Delete(groupCacheKey(userid, group))
+
+		log.Info().Str("group", rules.GroupJID).Bool("enabled", rules.Enabled).Msg("Group moderation rules saved")
+		responseJson, err := json.Marshal(rules)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Deletes the moderation rules of a group
+func (s *server) DeleteGroupModeration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		groupJID, err := groupJIDParam(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if groupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing groupJID parameter"))
+			return
+		}
+
+		result, err := s.db.Exec(`DELETE FROM group_moderation WHERE user_id=$1 AND group_jid=$2`, userid, groupJID)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not delete moderation rules: %v", err)))
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Moderation rules not found"))
+			return
+		}
+		group, _ := types.ParseJID(groupJID)
+		moderationCache.Delete(groupCacheKey(userid, group))
+
+		response := map[string]interface{}{"Details": "Moderation rules deleted"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Lists the latest moderation actions, of a group or of all groups
+func (s *server) GetGroupModerationLog() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		groupJID, err := groupJIDParam(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		limit := moderationLogLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > moderationLogMaxLimit {
+				s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("limit must be between 1 and %d", moderationLogMaxLimit)))
+				return
+			}
+		}
+
+		actions := []moderationAction{}
+		err = s.db.Select(&actions, `SELECT group_jid, participant, message_id, rule, detail, actions, strikes, created_at
+			FROM group_moderation_log WHERE user_id=$1 AND ($2 = '' OR group_jid=$2) ORDER BY created_at DESC LIMIT $3`,
+			userid, groupJID, limit)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list moderation log: %v", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(actions)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Lists the strikes of the participants of a group
+func (s *server) GetGroupModerationStrikes() http.HandlerFunc {
+
+	type strike struct {
+		Participant string    `db:"participant"`
+		Strikes     int       `db:"strikes"`
+		UpdatedAt   time.Time `db:"updated_at"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		groupJID, err := groupJIDParam(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if groupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing groupJID parameter"))
+			return
+		}
+
+		strikes := []strike{}
+		err = s.db.Select(&strikes, `SELECT participant, strikes, updated_at FROM group_moderation_strikes
+			WHERE user_id=$1 AND group_jid=$2 ORDER BY strikes DESC, participant`, userid, groupJID)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list moderation strikes: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"GroupJID": groupJID, "Strikes": strikes}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Clears the strikes of a participant, or of everyone in the group when no
+// participant is given
+func (s *server) ResetGroupModerationStrikes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		groupJID, err := groupJIDParam(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if groupJID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing groupJID parameter"))
+			return
+		}
+		participant := r.URL.Query().Get("participant")
+		if participant != "" {
+			jid, ok := parseJID(participant)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Participant"))
+				return
+			}
+			participant = jid.ToNonAD().String()
+		}
+
+		result, err := s.db.Exec(`DELETE FROM group_moderation_strikes WHERE user_id=$1 AND group_jid=$2 AND ($3 = '' OR participant=$3)`,
+			userid, groupJID, participant)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not reset moderation strikes: %v", err)))
+			return
+		}
+		reset, _ := result.RowsAffected()
+
+		response := map[string]interface{}{"Details": fmt.Sprintf("Strikes of %d participants reset", reset)}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
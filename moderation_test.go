@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestModerationLinks(t *testing.T) {
+	rules := &moderationRules{BlockLinks: true, AllowedDomains: []string{"www.Example.com"}}
+	if err := rules.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		link string // empty when the text has no blocked link
+	}{
+		{"see file.txt", ""},
+		{"ok.so", ""},
+		{"i.e. this one", ""},
+		{"write to john@gmail.com", ""},
+		{"version 1.2.3", ""},
+		{"visit spam.com now", "spam.com"},
+		{"http://foo.bar/x", "http://foo.bar/x"},
+		{"HTTPS://Foo.Bar", "HTTPS://Foo.Bar"},
+		{"www.evil.de", "www.evil.de"},
+		{"loja.com.br/promo", "loja.com.br/promo"},
+		{"join chat.whatsapp.com/AbC", "chat.whatsapp.com/AbC"},
+		{"https://sub.example.com/ok", ""},
+		{"www.example.com", ""},
+		{"example.com.evil.io", "example.com.evil.io"},
+		{"notexample.com", "notexample.com"},
+	}
+	for _, test := range tests {
+		rule, detail := rules.check(test.text, nil)
+		if test.link == "" {
+			if rule != "" {
+				t.Errorf("check(%q) = %q %q, want no violation", test.text, rule, detail)
+			}
+			continue
+		}
+		if rule != "link" || detail != test.link {
+			t.Errorf("check(%q) = %q %q, want link %q", test.text, rule, detail, test.link)
+		}
+	}
+}
+
+func TestIsBareLink(t *testing.T) {
+	tests := []struct {
+		text  string
+		start int
+		host  string
+		want  bool
+	}{
+		{"spam.com", 0, "spam.com", true},
+		{"loja.com.br", 0, "loja.com.br", true},
+		{"bit.ly", 0, "bit.ly", true},
+		{"file.txt", 0, "file.txt", false},
+		{"com.txt", 0, "com.txt", false},
+		{"a@spam.com", 2, "spam.com", false},
+	}
+	for _, test := range tests {
+		if got := isBareLink(test.text, test.start, test.host); got != test.want {
+			t.Errorf("isBareLink(%q, %d, %q) = %v, want %v", test.text, test.start, test.host, got, test.want)
+		}
+	}
+}
+
+func TestModerationRules(t *testing.T) {
+	rules := &moderationRules{
+		BannedWords:    []string{"spam", " ", "grátis"},
+		BannedPatterns: []string{`\d{4}-\d{4}`},
+		BlockForwarded: true,
+	}
+	if err := rules.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text      string
+		forwarded uint32
+		rule      string
+	}{
+		{"hello", 0, ""},
+		{"SPAM here", 0, "banned_word"},
+		{"spammer", 0, ""},
+		{"é grátis!", 0, "banned_word"},
+		{"call 1234-5678", 0, "banned_pattern"},
+		{"hello", 1, ""},
+		{"hello", frequentlyForwardedScore, "forwarded"},
+	}
+	for _, test := range tests {
+		contextInfo := &waProto.ContextInfo{ForwardingScore: proto.Uint32(test.forwarded)}
+		if rule, _ := rules.check(test.text, contextInfo); rule != test.rule {
+			t.Errorf("check(%q, forwarded %d) = %q, want %q", test.text, test.forwarded, rule, test.rule)
+		}
+	}
+}
+
+func TestModerationCompile(t *testing.T) {
+	tests := []struct {
+		rules moderationRules
+		ok    bool
+	}{
+		{moderationRules{}, true},
+		{moderationRules{FloodMessages: 5, FloodSeconds: 10}, true},
+		{moderationRules{FloodMessages: 5}, false},
+		{moderationRules{FloodMessages: -1, FloodSeconds: 10}, false},
+		{moderationRules{MaxStrikes: -1}, false},
+		{moderationRules{BannedPatterns: []string{"("}}, false},
+	}
+	for i, test := range tests {
+		if err := test.rules.compile(); (err == nil) != test.ok {
+			t.Errorf("compile of rules %d: err = %v, want ok %v", i, err, test.ok)
+		}
+	}
+}
+
+func TestIsFlooding(t *testing.T) {
+	rules := &moderationRules{FloodMessages: 3, FloodSeconds: 10}
+	group := types.NewJID("120362023605733675", types.GroupServer)
+	sender := types.NewJID("5491155554444", types.DefaultUserServer)
+	start := time.Now()
+
+	steps := []struct {
+		after time.Duration
+		want  bool
+	}{
+		{0, false},
+		{time.Second, false},
+		{2 * time.Second, false},
+		{3 * time.Second, true},  // fourth message within 10 seconds
+		{4 * time.Second, false}, // the count started over
+		{30 * time.Second, false},
+	}
+	for i, step := range steps {
+		if got := isFlooding(-1, group, sender, rules, start.Add(step.after)); got != step.want {
+			t.Errorf("message %d: isFlooding = %v, want %v", i, got, step.want)
+		}
+	}
+
+	if isFlooding(-1, group, sender, &moderationRules{}, start) {
+		t.Error("isFlooding without a flood rule = true, want false")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Message webhooks carry, next to the raw event, a flat description of the
+// message that does not need any knowledge of the WhatsApp protobufs.
+// Wrappers like ephemeral, view once and edits are unwrapped into flags.
+// Fields are only added to a schema version, anything else is a new version.
+
+const messageSchemaVersion = 1
+
+type normalizedMessage struct {
+	Version   int       `json:"version"`
+	ID        string    `json:"id"`
+	Chat      string    `json:"chat"`
+	Sender    string    `json:"sender"`
+	PushName  string    `json:"pushName"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// text, image, video, audio, document, sticker, location, contact,
+	// poll, poll_vote, reaction, edit, revoke, button_reply, list_reply,
+	// interactive, interactive_reply or unknown
+	Kind     string           `json:"kind"`
+	Text     string           `json:"text,omitempty"` // text, caption, edited text, reaction emoji or selected reply
+	Quoted   *quotedReference `json:"quoted,omitempty"`
+	Mentions []string         `json:"mentions,omitempty"`
+	Media    *normalizedMedia `json:"media,omitempty"`
+	TargetID string           `json:"targetId,omitempty"` // message reacted to, edited, revoked or voted on
+	ReplyID  string           `json:"replyId,omitempty"`  // id of the selected button or row
+	Vote     []string         `json:"vote,omitempty"`     // selected poll options
+	Contacts []contactCard    `json:"contacts,omitempty"` // contacts parsed from the vCards
+
+	IsGroup     bool `json:"isGroup"`
+	IsStatus    bool `json:"isStatus"`
+	FromMe      bool `json:"fromMe"`
+	IsEphemeral bool `json:"isEphemeral"`
+	IsViewOnce  bool `json:"isViewOnce"`
+	IsEdit      bool `json:"isEdit"`
+	IsForwarded bool `json:"isForwarded"`
+}
+
+type quotedReference struct {
+	ID          string `json:"id"`
+	Participant string `json:"participant,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Text        string `json:"text,omitempty"`
+}
+
+// Fields needed by the /chat/download* endpoints
+type normalizedMedia struct {
+	MimeType      string  `json:"mimetype"`
+	FileName      string  `json:"fileName,omitempty"`
+	FileLength    uint64  `json:"fileLength"`
+	Seconds       uint32  `json:"seconds,omitempty"`
+	PTT           bool    `json:"ptt,omitempty"`
+	Width         uint32  `json:"width,omitempty"`
+	Height        uint32  `json:"height,omitempty"`
+	URL           string  `json:"url"`
+	DirectPath    string  `json:"directPath"`
+	MediaKey      []byte  `json:"mediaKey"`
+	FileSHA256    []byte  `json:"fileSHA256"`
+	FileEncSHA256 []byte  `json:"fileEncSHA256"`
+	Latitude      float64 `json:"latitude,omitempty"`
+	Longitude     float64 `json:"longitude,omitempty"`
+}
+
+// Describes the content of a message. Returns the kind, text, media and
+// context info of it.
+func describeContent(msg *waProto.Message) (string, string, *normalizedMedia, *waProto.ContextInfo) {
+	switch {
+	case msg == nil:
+		return "unknown", "", nil, nil
+	case msg.Conversation != nil:
+		return "text", msg.GetConversation(), nil, nil
+	case msg.ExtendedTextMessage != nil:
+		m := msg.ExtendedTextMessage
+		return "text", m.GetText(), nil, m.GetContextInfo()
+	case msg.ImageMessage != nil:
+		m := msg.ImageMessage
+		return "image", m.GetCaption(), &normalizedMedia{
+			MimeType: m.GetMimetype(), FileLength: m.GetFileLength(), Width: m.GetWidth(), Height: m.GetHeight(),
+			URL: m.GetURL(), DirectPath: m.GetDirectPath(), MediaKey: m.GetMediaKey(),
+			FileSHA256: m.GetFileSHA256(), FileEncSHA256: m.GetFileEncSHA256(),
+		}, m.GetContextInfo()
+	case msg.VideoMessage != nil:
+		m := msg.VideoMessage
+		return "video", m.GetCaption(), &normalizedMedia{
+			MimeType: m.GetMimetype(), FileLength: m.GetFileLength(), Seconds: m.GetSeconds(), Width: m.GetWidth(), Height: m.GetHeight(),
+			URL: m.GetURL(), DirectPath: m.GetDirectPath(), MediaKey: m.GetMediaKey(),
+			FileSHA256: m.GetFileSHA256(), FileEncSHA256: m.GetFileEncSHA256(),
+		}, m.GetContextInfo()
+	case msg.AudioMessage != nil:
+		m := msg.AudioMessage
+		return "audio", "", &normalizedMedia{
+			MimeType: m.GetMimetype(), FileLength: m.GetFileLength(), Seconds: m.GetSeconds(), PTT: m.GetPTT(),
+			URL: m.GetURL(), DirectPath: m.GetDirectPath(), MediaKey: m.GetMediaKey(),
+			FileSHA256: m.GetFileSHA256(), FileEncSHA256: m.GetFileEncSHA256(),
+		}, m.GetContextInfo()
+	case msg.DocumentMessage != nil:
+		m := msg.DocumentMessage
+		return "document", m.GetCaption(), &normalizedMedia{
+			MimeType: m.GetMimetype(), FileName: m.GetFileName(), FileLength: m.GetFileLength(),
+			URL: m.GetURL(), DirectPath: m.GetDirectPath(), MediaKey: m.GetMediaKey(),
+			FileSHA256: m.GetFileSHA256(), FileEncSHA256: m.GetFileEncSHA256(),
+		}, m.GetContextInfo()
+	case msg.StickerMessage != nil:
+		m := msg.StickerMessage
+		return "sticker", "", &normalizedMedia{
+			MimeType: m.GetMimetype(), FileLength: m.GetFileLength(), Width: m.GetWidth(), Height: m.GetHeight(),
+			URL: m.GetURL(), DirectPath: m.GetDirectPath(), MediaKey: m.GetMediaKey(),
+			FileSHA256: m.GetFileSHA256(), FileEncSHA256: m.GetFileEncSHA256(),
+		}, m.GetContextInfo()
+	case msg.LocationMessage != nil:
+		m := msg.LocationMessage
+		return "location", m.GetName(), &normalizedMedia{Latitude: m.GetDegreesLatitude(), Longitude: m.GetDegreesLongitude()}, m.GetContextInfo()
+	case msg.LiveLocationMessage != nil:
+		m := msg.LiveLocationMessage
+		return "location", m.GetCaption(), &normalizedMedia{Latitude: m.GetDegreesLatitude(), Longitude: m.GetDegreesLongitude()}, m.GetContextInfo()
+	case msg.ContactMessage != nil:
+		m := msg.ContactMessage
+		return "contact", m.GetVcard(), nil, m.GetContextInfo()
+	case msg.ContactsArrayMessage != nil:
+		m := msg.ContactsArrayMessage
+		return "contact", m.GetDisplayName(), nil, m.GetContextInfo()
+	case pollOf(msg) != nil:
+		poll := pollOf(msg)
+		return "poll", poll.GetName(), nil, poll.GetContextInfo()
+	case msg.ButtonsResponseMessage != nil:
+		m := msg.ButtonsResponseMessage
+		return "button_reply", m.GetSelectedDisplayText(), nil, m.GetContextInfo()
+	case msg.TemplateButtonReplyMessage != nil:
+		m := msg.TemplateButtonReplyMessage
+		return "button_reply", m.GetSelectedDisplayText(), nil, m.GetContextInfo()
+	case msg.ListResponseMessage != nil:
+		m := msg.ListResponseMessage
+		return "list_reply", m.GetTitle(), nil, m.GetContextInfo()
+	case msg.InteractiveMessage != nil:
+		m := msg.InteractiveMessage
+		return "interactive", m.GetBody().GetText(), nil, m.GetContextInfo()
+	case msg.InteractiveResponseMessage != nil:
+		m := msg.InteractiveResponseMessage
+		return "interactive_reply", m.GetBody().GetText(), nil, m.GetContextInfo()
+	}
+	return "unknown", "", nil, nil
+}
+
+// Builds the normalized description of a received or sent message. The vote
+// is the decrypted poll vote, if the message is one.
+func normalizeMessage(evt *events.Message, vote *pollVote) *normalizedMessage {
+	n := &normalizedMessage{
+		Version:     messageSchemaVersion,
+		ID:          evt.Info.ID,
+		Chat:        evt.Info.Chat.String(),
+		Sender:      evt.Info.Sender.ToNonAD().String(),
+		PushName:    evt.Info.PushName,
+		Timestamp:   evt.Info.Timestamp,
+		IsGroup:     evt.Info.IsGroup,
+		IsStatus:    evt.Info.Chat == types.StatusBroadcastJID,
+		FromMe:      evt.Info.IsFromMe,
+		IsEphemeral: evt.IsEphemeral,
+		IsViewOnce:  evt.IsViewOnce,
+		IsEdit:      evt.IsEdit,
+	}
+	msg := evt.Message
+
+	var contextInfo *waProto.ContextInfo
+	switch {
+	case msg.GetProtocolMessage() != nil:
+		protocol := msg.GetProtocolMessage()
+		n.TargetID = protocol.GetKey().GetID()
+		switch protocol.GetType() {
+		case waProto.ProtocolMessage_REVOKE:
+			n.Kind = "revoke"
+		case waProto.ProtocolMessage_MESSAGE_EDIT:
+			n.Kind = "edit"
+			n.IsEdit = true
+			_, n.Text, _, contextInfo = describeContent(protocol.GetEditedMessage())
+		default:
+			n.Kind = "unknown"
+		}
+	case msg.GetReactionMessage() != nil:
+		n.Kind = "reaction"
+		n.Text = msg.GetReactionMessage().GetText()
+		n.TargetID = msg.GetReactionMessage().GetKey().GetID()
+	case msg.GetPollUpdateMessage() != nil:
+		n.Kind = "poll_vote"
+		n.TargetID = msg.GetPollUpdateMessage().GetPollCreationMessageKey().GetID()
+		if vote != nil {
+			n.Vote = vote.Options
+		}
+	default:
+		n.Kind, n.Text, n.Media, contextInfo = describeContent(msg)
+		switch {
+		case msg.GetContactMessage() != nil:
+			n.Contacts = []contactCard{parseVcard(msg.GetContactMessage().GetVcard())}
+		case msg.GetContactsArrayMessage() != nil:
+			for _, contact := range msg.GetContactsArrayMessage().GetContacts() {
+				n.Contacts = append(n.Contacts, parseVcard(contact.GetVcard()))
+			}
+		}
+		if reply := interactiveReplyOf(evt); reply != nil {
+			n.ReplyID = reply.SelectedId
+		}
+	}
+
+	if contextInfo != nil {
+		n.Mentions = contextInfo.GetMentionedJID()
+		n.IsForwarded = contextInfo.GetIsForwarded()
+		if contextInfo.GetStanzaID() != "" {
+			n.Quoted = &quotedReference{ID: contextInfo.GetStanzaID(), Participant: contextInfo.GetParticipant()}
+			if contextInfo.QuotedMessage != nil {
+				n.Quoted.Kind, n.Quoted.Text, _, _ = describeContent(contextInfo.GetQuotedMessage())
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Poll votes arrive encrypted with the secret of the poll. They are
+// decrypted, kept per voter, since every vote replaces the previous one,
+// and matched to the option names of the poll kept in the message store.
+
+// Posted with the PollVote webhook
+type pollVote struct {
+	PollId    string
+	Chat      string
+	Voter     string
+	PushName  string
+	Question  string   `json:",omitempty"`
+	Options   []string // selected option names, empty when the vote was removed
+	Timestamp time.Time
+}
+
+type pollOption struct {
+	Name   string
+	Votes  int
+	Voters []string
+}
+
+// Returns the poll carried by a message, if any
+func pollOf(msg *waProto.Message) *waProto.PollCreationMessage {
+	switch {
+	case msg.PollCreationMessage != nil:
+		return msg.PollCreationMessage
+	case msg.PollCreationMessageV2 != nil:
+		return msg.PollCreationMessageV2
+	case msg.PollCreationMessageV3 != nil:
+		return msg.PollCreationMessageV3
+	case msg.PollCreationMessageV5 != nil:
+		return msg.PollCreationMessageV5
+	}
+	return nil
+}
+
+// Returns the option names of a poll by the hex encoded hash used in votes
+func pollOptionHashes(poll *waProto.PollCreationMessage) ([]string, map[string]string) {
+	var names []string
+	for _, option := range poll.GetOptions() {
+		names = append(names, option.GetOptionName())
+	}
+	byHash := map[string]string{}
+	for i, hash := range whatsmeow.HashPollOptions(names) {
+		byHash[hex.EncodeToString(hash)] = names[i]
+	}
+	return names, byHash
+}
+
+// Decrypts a poll vote, keeps it and posts the PollVote webhook. Returns nil
+// for other messages or votes that could not be decrypted.
+func (mycli *MyClient) recordPollVote(evt *events.Message) *pollVote {
+	update := evt.Message.GetPollUpdateMessage()
+	if update == nil {
+		return nil
+	}
+	pollID := update.GetPollCreationMessageKey().GetID()
+
+	vote, err := mycli.WAClient.DecryptPollVote(context.Background(), evt)
+	if err != nil {
+		log.Warn().Err(err).Str("poll", pollID).Str("id", evt.Info.ID).Msg("Could not decrypt poll vote")
+		return nil
+	}
+
+	selected := []string{}
+	for _, hash := range vote.GetSelectedOptions() {
+		selected = append(selected, hex.EncodeToString(hash))
+	}
+	voter := evt.Info.Sender.ToNonAD().String()
+
+	_, err = mycli.db.Exec(`INSERT INTO poll_votes (user_id, poll_id, voter, selected, timestamp) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, poll_id, voter) DO UPDATE SET selected=EXCLUDED.selected, timestamp=EXCLUDED.timestamp
+		WHERE poll_votes.timestamp <= EXCLUDED.timestamp`,
+		mycli.userID, pollID, voter, pq.Array(selected), evt.Info.Timestamp)
+	if err != nil {
+		log.Error().Err(err).Str("poll", pollID).Msg("Could not store poll vote")
+	}
+
+	event := pollVote{
+		PollId:    pollID,
+		Chat:      evt.Info.Chat.String(),
+		Voter:     voter,
+		PushName:  evt.Info.PushName,
+		Options:   []string{},
+		Timestamp: evt.Info.Timestamp,
+	}
+	stored, err := loadStoredMessage(mycli.db, mycli.userID, pollID)
+	if err == nil && pollOf(stored.Message) != nil {
+		poll := pollOf(stored.Message)
+		_, byHash := pollOptionHashes(poll)
+		event.Question = poll.GetName()
+		for _, hash := range selected {
+			if name, ok := byHash[hash]; ok {
+				event.Options = append(event.Options, name)
+			}
+		}
+	} else {
+		log.Warn().Str("poll", pollID).Msg("Poll not found in the message store, vote sent without option names")
+	}
+
+	log.Info().Str("poll", pollID).Str("voter", voter).Strs("options", event.Options).Msg("Poll vote received")
+	sendEventWebhook(mycli.userID, mycli.token, "PollVote", event)
+	return &event
+}
+
+// Returns the current tally of a poll
+func (s *server) GetPollResults() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		id := mux.Vars(r)["id"]
+
+		stored, err := loadStoredMessage(s.db, userid, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Poll not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not load poll: %v", err)))
+			return
+		}
+		poll := pollOf(stored.Message)
+		if poll == nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Message is not a poll"))
+			return
+		}
+
+		var votes []struct {
+			Voter    string         `db:"voter"`
+			Selected pq.StringArray `db:"selected"`
+		}
+		err = s.db.Select(&votes, "SELECT voter, selected FROM poll_votes WHERE user_id=$1 AND poll_id=$2 ORDER BY timestamp", userid, id)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not load poll votes: %v", err)))
+			return
+		}
+
+		names, byHash := pollOptionHashes(poll)
+		options := make([]pollOption, len(names))
+		index := map[string]int{}
+		for i, name := range names {
+			options[i] = pollOption{Name: name, Voters: []string{}}
+			index[name] = i
+		}
+		voters := 0
+		for _, vote := range votes {
+			if len(vote.Selected) > 0 {
+				voters++
+			}
+			for _, hash := range vote.Selected {
+				if name, ok := byHash[hash]; ok {
+					options[index[name]].Votes++
+					options[index[name]].Voters = append(options[index[name]].Voters, vote.Voter)
+				}
+			}
+		}
+
+		response := map[string]interface{}{
+			"Id":              id,
+			"Chat":            stored.Chat.String(),
+			"Question":        poll.GetName(),
+			"SelectableCount": poll.GetSelectableOptionsCount(),
+			"Options":         options,
+			"TotalVoters":     voters,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Outbound message queue. Messages sent with "Async": true are stored in the
+// message_queue table and delivered by one worker per instance, which keeps
+// the order of messages per recipient and paces sends according to the
+// instance's queue_rate and queue_jitter settings.
+
+const (
+	jobQueued    = "queued"
+	jobSending   = "sending"
+	jobSent      = "sent"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+	jobDropped   = "dropped"
+
+	queuePollInterval = 5 * time.Second
+	queueRetryDelay   = 10 * time.Second
+	queueSendTimeout  = 2 * time.Minute
+	// A job still sending after this long was interrupted, every send gives
+	// up within queueSendTimeout
+	queueStaleAfter = queueSendTimeout + time.Minute
+)
+
+type queueWorker struct {
+	userID int
+	token  string
+	db     *sqlx.DB
+	wake   chan struct{}
+	quit   chan struct{}
+}
+
+type queuedMessage struct {
+	ID        int64      `db:"id"`
+	JobID     string     `db:"job_id"`
+	Recipient string     `db:"recipient"`
+	MessageID string     `db:"message_id"`
+	Message   []byte     `db:"message"`
+	Attempts  int        `db:"attempts"`
+	SendAt    *time.Time `db:"send_at"`
+	Typing    bool       `db:"simulate_typing"`
+}
+
+// Public view of a queued message, used by the jobs endpoint and the SendJob webhook
+type sendJob struct {
+	JobId     string     `db:"job_id"`
+	Id        string     `db:"message_id"`
+	Phone     string     `db:"recipient"`
+	Status    string     `db:"status"`
+	Attempts  int        `db:"attempts"`
+	Error     string     `db:"last_error"`
+	Timestamp *time.Time `db:"sent_at"`
+	SendAt    *time.Time `db:"send_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+var (
+	queueWorkers      = make(map[int]*queueWorker)
+	queueWorkersMutex sync.Mutex
+
+	// Next send of every instance, shared by the outbound queue and campaigns
+	sendSlots      = make(map[int]time.Time)
+	sendSlotsMutex sync.Mutex
+)
+
+// Starts the outbound queue worker for an instance if it is not running yet
+func startQueueWorker(db *sqlx.DB, userID int, token string) {
+	queueWorkersMutex.Lock()
+	defer queueWorkersMutex.Unlock()
+
+	if _, ok := queueWorkers[userID]; ok {
+		return
+	}
+	qw := &queueWorker{
+		userID: userID,
+		token:  token,
+		db:     db,
+		wake:   make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+	queueWorkers[userID] = qw
+	go qw.run()
+}
+
+// Stops the outbound queue worker for an instance, pending jobs stay in the database
+func stopQueueWorker(userID int) {
+	queueWorkersMutex.Lock()
+	defer queueWorkersMutex.Unlock()
+
+	if qw, ok := queueWorkers[userID]; ok {
+		close(qw.quit)
+		delete(queueWorkers, userID)
+	}
+}
+
+// Tells the worker of an instance that there is new work, without waiting for the next poll
+func wakeQueueWorker(userID int) {
+	queueWorkersMutex.Lock()
+	defer queueWorkersMutex.Unlock()
+
+	if qw, ok := queueWorkers[userID]; ok {
+		select {
+		case qw.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Stores a message in the outbound queue of an instance and returns the job id.
+// Scheduled messages pass the time they are due in sendAt, nil means as soon as possible.
+// With typing set, the worker simulates typing in the chat before sending.
+func (s *server) enqueueMessage(userID int, recipient types.JID, msgID string, msg *waProto.Message, sendAt *time.Time, typing bool) (string, error) {
+	jobID, err := insertQueuedMessage(s.db, userID, recipient, msgID, msg, sendAt, typing)
+	if err != nil {
+		return "", err
+	}
+
+	wakeQueueWorker(userID)
+	return jobID, nil
+}
+
+// Inserts a job in the outbound queue without waking the worker, so several
+// jobs can be queued in one transaction
+func insertQueuedMessage(db sqlx.Execer, userID int, recipient types.JID, msgID string, msg *waProto.Message, sendAt *time.Time, typing bool) (string, error) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	jobID, err := generateJobID()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(`INSERT INTO message_queue (job_id, user_id, recipient, message_id, message, send_at, simulate_typing, next_attempt_at) VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($6, NOW()))`,
+		jobID, userID, recipient.String(), msgID, data, sendAt, typing)
+	if err != nil {
+		return "", err
+	}
+	return jobID, nil
+}
+
+func generateJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (qw *queueWorker) run() {
+	log.Info().Int("userid", qw.userID).Msg("Starting outbound queue worker")
+
+	qw.requeueStale()
+
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-qw.quit:
+			log.Info().Int("userid", qw.userID).Msg("Stopping outbound queue worker")
+			return
+		case <-qw.wake:
+		case <-ticker.C:
+			qw.requeueStale()
+		}
+
+		for qw.ready() {
+			job, err := qw.claim()
+			if err != nil {
+				log.Error().Err(err).Int("userid", qw.userID).Msg("Could not fetch next queued message")
+				break
+			}
+			if job == nil {
+				break
+			}
+
+			if qw.pastDue(job) {
+				log.Warn().Str("job", job.JobID).Time("send_at", *job.SendAt).Msg("Dropping past due scheduled message")
+				qw.finish(job, jobDropped, job.Attempts, nil, "scheduled time passed before the message could be sent")
+				continue
+			}
+
+			if wait := qw.delay(); wait > 0 {
+				select {
+				case <-qw.quit:
+					qw.release(job)
+					log.Info().Int("userid", qw.userID).Msg("Stopping outbound queue worker")
+					return
+				case <-time.After(wait):
+				}
+			}
+
+			qw.process(job)
+		}
+	}
+}
+
+// Puts back in the queue the jobs left sending by a restart or disconnection.
+// Jobs sent right now, by the previous worker of a reconnected instance or by
+// another replica, are younger than queueStaleAfter and are left alone.
+func (qw *queueWorker) requeueStale() {
+	_, err := qw.db.Exec(`UPDATE message_queue SET status=$1, updated_at=NOW()
+		WHERE user_id=$2 AND status=$3 AND updated_at < NOW() - $4 * INTERVAL '1 second'`,
+		jobQueued, qw.userID, jobSending, int(queueStaleAfter.Seconds()))
+	if err != nil {
+		log.Error().Err(err).Int("userid", qw.userID).Msg("Could not requeue interrupted jobs")
+	}
+}
+
+// Only send while the instance is connected, otherwise jobs wait in the queue
+func (qw *queueWorker) ready() bool {
+	client := clientPointer[qw.userID]
+	return client != nil && client.IsConnected() && client.IsLoggedIn()
+}
+
+// Time to wait before the next send, based on the instance rate and jitter
+func (qw *queueWorker) delay() time.Duration {
+	settings, err := getInstanceSettings(qw.db, qw.userID)
+	if err != nil {
+		log.Warn().Err(err).Int("userid", qw.userID).Msg("Could not load instance settings, using defaults")
+	}
+
+	wait := reserveSendSlot(qw.userID, settings.QueueRate)
+	if settings.QueueJitter > 0 {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(settings.QueueJitter)+1))
+		if err == nil {
+			wait += time.Duration(n.Int64()) * time.Millisecond
+		}
+	}
+	return wait
+}
+
+// Reserves the next send of an instance at the given rate per minute and
+// returns how long to wait for it. The outbound queue and the campaigns of an
+// instance take turns on the same slots, so together they keep to the rate.
+func reserveSendSlot(userID int, rate int) time.Duration {
+	sendSlotsMutex.Lock()
+	defer sendSlotsMutex.Unlock()
+
+	now := time.Now()
+	slot := sendSlots[userID].Add(time.Minute / time.Duration(rate))
+	if slot.Before(now) {
+		slot = now
+	}
+	sendSlots[userID] = slot
+	return slot.Sub(now)
+}
+
+// Takes the oldest due job whose recipient has no earlier job still pending.
+// Jobs are ordered by the time they are due, so a message scheduled for
+// tomorrow does not hold back the ones sent today to the same recipient.
+func (qw *queueWorker) claim() (*queuedMessage, error) {
+	var job queuedMessage
+	err := qw.db.Get(&job, `
+		UPDATE message_queue SET status=$2, updated_at=NOW()
+		WHERE id = (
+			SELECT q.id FROM message_queue q
+			WHERE q.user_id=$1 AND q.status=$3 AND q.next_attempt_at <= NOW()
+			AND NOT EXISTS (
+				SELECT 1 FROM message_queue p
+				WHERE p.user_id=q.user_id AND p.recipient=q.recipient
+				AND p.status IN ($2, $3)
+				AND (COALESCE(p.send_at, p.created_at), p.id) < (COALESCE(q.send_at, q.created_at), q.id)
+			)
+			ORDER BY COALESCE(q.send_at, q.created_at), q.id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, job_id, recipient, message_id, message, attempts, send_at, simulate_typing`, qw.userID, jobSending, jobQueued)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Scheduled jobs that could not be sent in time are dropped when the instance policy says so
+func (qw *queueWorker) pastDue(job *queuedMessage) bool {
+	if job.SendAt == nil || time.Since(*job.SendAt) <= time.Duration(*scheduledGrace)*time.Second {
+		return false
+	}
+	settings, err := getInstanceSettings(qw.db, qw.userID)
+	if err != nil {
+		log.Warn().Err(err).Int("userid", qw.userID).Msg("Could not load instance settings, using defaults")
+	}
+	return settings.ScheduledPolicy == scheduledDrop
+}
+
+func (qw *queueWorker) release(job *queuedMessage) {
+	_, err := qw.db.Exec(`UPDATE message_queue SET status=$1, updated_at=NOW() WHERE id=$2`, jobQueued, job.ID)
+	if err != nil {
+		log.Error().Err(err).Str("job", job.JobID).Msg("Could not release queued message")
+	}
+}
+
+func (qw *queueWorker) process(job *queuedMessage) {
+	// The instance may have logged out while waiting, the job then waits for the next connection
+	client := clientPointer[qw.userID]
+	if client == nil {
+		qw.release(job)
+		return
+	}
+	attempts := job.Attempts + 1
+
+	msg := &waProto.Message{}
+	if err := proto.Unmarshal(job.Message, msg); err != nil {
+		qw.finish(job, jobFailed, attempts, nil, err.Error())
+		return
+	}
+	recipient, err := types.ParseJID(job.Recipient)
+	if err != nil {
+		qw.finish(job, jobFailed, attempts, nil, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queueSendTimeout)
+	defer cancel()
+	if job.Typing {
+		showTyping(ctx, client, recipient, msg)
+	}
+	resp, err := client.SendMessage(ctx, recipient, msg, sendRequestExtra(msg, job.MessageID))
+	if job.Typing {
+		stopTyping(client, recipient)
+	}
+	if err != nil {
+		if isTransientSendError(err) && attempts < *queueRetries {
+			backoff := time.Duration(attempts*attempts) * queueRetryDelay
+			log.Warn().Err(err).Str("job", job.JobID).Int("attempt", attempts).Dur("retry_in", backoff).Msg("Queued message failed, will retry")
+			_, dberr := qw.db.Exec(`UPDATE message_queue SET status=$1, attempts=$2, last_error=$3, next_attempt_at=NOW() + $4 * INTERVAL '1 second', updated_at=NOW() WHERE id=$5`,
+				jobQueued, attempts, err.Error(), int(backoff.Seconds()), job.ID)
+			if dberr != nil {
+				log.Error().Err(dberr).Str("job", job.JobID).Msg("Could not reschedule queued message")
+			}
+			return
+		}
+		log.Error().Err(err).Str("job", job.JobID).Msg("Queued message failed")
+		qw.finish(job, jobFailed, attempts, nil, err.Error())
+		return
+	}
+
+	log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", job.MessageID).Str("job", job.JobID).Msg("Queued message sent")
+	storeSentMessage(qw.db, qw.userID, client, recipient, job.MessageID, msg, resp.Timestamp)
+	qw.finish(job, jobSent, attempts, &resp.Timestamp, "")
+}
+
+// Stores the final state of a job and reports it through the SendJob webhook
+func (qw *queueWorker) finish(job *queuedMessage, status string, attempts int, sentAt *time.Time, lastError string) {
+	_, err := qw.db.Exec(`UPDATE message_queue SET status=$1, attempts=$2, last_error=$3, sent_at=$4, updated_at=NOW() WHERE id=$5`,
+		status, attempts, lastError, sentAt, job.ID)
+	if err != nil {
+		log.Error().Err(err).Str("job", job.JobID).Msg("Could not update queued message")
+		return
+	}
+
+	result, err := getSendJob(qw.db, qw.userID, job.JobID)
+	if err != nil {
+		log.Error().Err(err).Str("job", job.JobID).Msg("Could not load queued message")
+		return
+	}
+	sendEventWebhook(qw.userID, qw.token, "SendJob", result)
+}
+
+// Errors worth retrying: connection problems, timeouts and server side failures
+func isTransientSendError(err error) bool {
+	if errors.Is(err, whatsmeow.ErrNotConnected) ||
+		errors.Is(err, whatsmeow.ErrIQTimedOut) ||
+		errors.Is(err, whatsmeow.ErrMessageTimedOut) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var disconnected *whatsmeow.DisconnectedError
+	if errors.As(err, &disconnected) {
+		return true
+	}
+	var iqErr *whatsmeow.IQError
+	if errors.As(err, &iqErr) {
+		return iqErr.Code == 429 || iqErr.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func getSendJob(db *sqlx.DB, userID int, jobID string) (sendJob, error) {
+	var job sendJob
+	err := db.Get(&job, `SELECT job_id, message_id, recipient, status, attempts, last_error, sent_at, send_at, created_at FROM message_queue WHERE user_id=$1 AND job_id=$2`, userID, jobID)
+	return job, err
+}
+
+// Gets the status of a queued message
+func (s *server) GetSendJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		vars := mux.Vars(r)
+		jobid := vars["id"]
+
+		job, err := getSendJob(s.db, userid, jobid)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Job not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not get job: %v", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(job)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
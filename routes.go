@@ -81,23 +81,61 @@ func (s *server) routes() {
 	s.router.Handle("/webhook", c.Then(s.DeleteWebhook())).Methods("DELETE")     // Nova rota
 	s.router.Handle("/webhook/update", c.Then(s.UpdateWebhook())).Methods("PUT") // Nova rota
 	s.router.Handle("/session/proxy", c.Then(s.SetProxy())).Methods("POST")
-
-	s.router.Handle("/chat/send/text", c.Then(s.SendMessage())).Methods("POST")
-	s.router.Handle("/chat/send/image", c.Then(s.SendImage())).Methods("POST")
-	s.router.Handle("/chat/send/audio", c.Then(s.SendAudio())).Methods("POST")
-	s.router.Handle("/chat/send/document", c.Then(s.SendDocument())).Methods("POST")
-	//	s.router.Handle("/chat/send/template", c.Then(s.SendTemplate())).Methods("POST")
-	s.router.Handle("/chat/send/video", c.Then(s.SendVideo())).Methods("POST")
-	s.router.Handle("/chat/send/sticker", c.Then(s.SendSticker())).Methods("POST")
-	s.router.Handle("/chat/send/location", c.Then(s.SendLocation())).Methods("POST")
-	s.router.Handle("/chat/send/contact", c.Then(s.SendContact())).Methods("POST")
+	s.router.Handle("/session/settings", c.Then(s.GetSettings())).Methods("GET")
+	s.router.Handle("/session/settings", c.Then(s.SetSettings())).Methods("POST")
+
+	// Retries of send requests with the same Idempotency-Key or Id return the original response
+	send := c.Append(s.idempotent)
+
+	s.router.Handle("/chat/send", send.Then(s.Send())).Methods("POST")
+	s.router.Handle("/chat/send/text", send.Then(s.SendMessage())).Methods("POST")
+	s.router.Handle("/chat/send/image", send.Then(s.SendImage())).Methods("POST")
+	s.router.Handle("/chat/send/audio", send.Then(s.SendAudio())).Methods("POST")
+	s.router.Handle("/chat/send/document", send.Then(s.SendDocument())).Methods("POST")
+	s.router.Handle("/chat/send/template", send.Then(s.SendTemplate())).Methods("POST")
+	s.router.Handle("/chat/send/video", send.Then(s.SendVideo())).Methods("POST")
+	s.router.Handle("/chat/send/album", send.Then(s.SendAlbum())).Methods("POST")
+	s.router.Handle("/chat/send/sticker", send.Then(s.SendSticker())).Methods("POST")
+	s.router.Handle("/chat/send/location", send.Then(s.SendLocation())).Methods("POST")
+	s.router.Handle("/chat/send/contact", send.Then(s.SendContact())).Methods("POST")
+	s.router.Handle("/chat/forward", send.Then(s.Forward())).Methods("POST")
 	s.router.Handle("/chat/react", c.Then(s.React())).Methods("POST")
 	s.router.Handle("/user/presence", c.Then(s.SendPresence())).Methods("POST")
 	s.router.Handle("/chat/edit", c.Then(s.Edit())).Methods("POST")
 	s.router.Handle("/chat/revoke", c.Then(s.Revoke())).Methods("POST")
-	s.router.Handle("/chat/send/buttons", c.Then(s.SendButtons())).Methods("POST")
-	s.router.Handle("/chat/send/list", c.Then(s.SendList())).Methods("POST")
-	s.router.Handle("/chat/send/poll", c.Then(s.SendPoll())).Methods("POST")
+	s.router.Handle("/chat/send/buttons", send.Then(s.SendButtons())).Methods("POST")
+	s.router.Handle("/chat/send/list", send.Then(s.SendList())).Methods("POST")
+	s.router.Handle("/chat/send/interactive", send.Then(s.SendInteractive())).Methods("POST")
+	s.router.Handle("/chat/send/poll", send.Then(s.SendPoll())).Methods("POST")
+	s.router.Handle("/chat/poll/{id}/results", c.Then(s.GetPollResults())).Methods("GET")
+	s.router.Handle("/chat/send/jobs/{id}", c.Then(s.GetSendJob())).Methods("GET")
+	s.router.Handle("/chat/scheduled", c.Then(s.ListScheduled())).Methods("GET")
+	s.router.Handle("/chat/scheduled/{id}", c.Then(s.CancelScheduled())).Methods("DELETE")
+
+	s.router.Handle("/templates", c.Then(s.ListTemplates())).Methods("GET")
+	s.router.Handle("/templates", c.Then(s.CreateTemplate())).Methods("POST")
+	s.router.Handle("/templates/{name}", c.Then(s.GetTemplate())).Methods("GET")
+	s.router.Handle("/templates/{name}", c.Then(s.UpdateTemplate())).Methods("PUT")
+	s.router.Handle("/templates/{name}", c.Then(s.DeleteTemplate())).Methods("DELETE")
+	s.router.Handle("/templates/{name}/versions", c.Then(s.ListTemplateVersions())).Methods("GET")
+	s.router.Handle("/templates/{name}/preview", c.Then(s.PreviewTemplate())).Methods("POST")
+
+	s.router.Handle("/campaigns", c.Then(s.ListCampaigns())).Methods("GET")
+	s.router.Handle("/campaigns", c.Then(s.CreateCampaign())).Methods("POST")
+	s.router.Handle("/campaigns/{id}", c.Then(s.GetCampaign())).Methods("GET")
+	s.router.Handle("/campaigns/{id}/report", c.Then(s.GetCampaignReport())).Methods("GET")
+	s.router.Handle("/campaigns/{id}/recipients", c.Then(s.AddCampaignRecipients())).Methods("POST")
+	s.router.Handle("/campaigns/{id}/start", c.Then(s.ChangeCampaignState("start"))).Methods("POST")
+	s.router.Handle("/campaigns/{id}/pause", c.Then(s.ChangeCampaignState("pause"))).Methods("POST")
+	s.router.Handle("/campaigns/{id}/resume", c.Then(s.ChangeCampaignState("resume"))).Methods("POST")
+	s.router.Handle("/campaigns/{id}/cancel", c.Then(s.ChangeCampaignState("cancel"))).Methods("POST")
+
+	s.router.Handle("/status/send/text", send.Then(s.SendStatusText())).Methods("POST")
+	s.router.Handle("/status/send/image", send.Then(s.SendStatusImage())).Methods("POST")
+	s.router.Handle("/status/send/video", send.Then(s.SendStatusVideo())).Methods("POST")
+	s.router.Handle("/status/audience", c.Then(s.GetStatusAudience())).Methods("GET")
+	s.router.Handle("/status/list", c.Then(s.ListStatuses())).Methods("GET")
+	s.router.Handle("/status/seen", c.Then(s.MarkStatusesSeen())).Methods("POST")
 
 	s.router.Handle("/user/info", c.Then(s.GetUser())).Methods("POST")
 	s.router.Handle("/user/check", c.Then(s.CheckUser())).Methods("POST")
@@ -106,6 +144,11 @@ func (s *server) routes() {
 
 	s.router.Handle("/chat/presence", c.Then(s.ChatPresence())).Methods("POST")
 	s.router.Handle("/chat/markread", c.Then(s.MarkRead())).Methods("POST")
+	s.router.Handle("/chat/archive", c.Then(s.ArchiveChat())).Methods("POST")
+	s.router.Handle("/chat/pin", c.Then(s.PinChat())).Methods("POST")
+	s.router.Handle("/chat/mute", c.Then(s.MuteChat())).Methods("POST")
+	s.router.Handle("/chat/star", c.Then(s.StarMessage())).Methods("POST")
+	s.router.Handle("/chat/deleteforme", c.Then(s.DeleteForMe())).Methods("POST")
 	s.router.Handle("/chat/downloadimage", c.Then(s.DownloadImage())).Methods("POST")
 	s.router.Handle("/chat/downloadvideo", c.Then(s.DownloadVideo())).Methods("POST")
 	s.router.Handle("/chat/downloadaudio", c.Then(s.DownloadAudio())).Methods("POST")
@@ -114,6 +157,7 @@ func (s *server) routes() {
 	s.router.Handle("/group/list", c.Then(s.ListGroups())).Methods("GET")
 	s.router.Handle("/group/create", c.Then(s.CreateGroup())).Methods("POST")
 	s.router.Handle("/group/info", c.Then(s.GetGroupInfo())).Methods("GET")
+	s.router.Handle("/group/cached", c.Then(s.GetCachedGroups())).Methods("GET")
 	s.router.Handle("/group/invitelink", c.Then(s.GetGroupInviteLink())).Methods("GET")
 	s.router.Handle("/group/photo", c.Then(s.SetGroupPhoto())).Methods("POST")
 	s.router.Handle("/group/photo/remove", c.Then(s.RemoveGroupPhoto())).Methods("POST")
@@ -126,6 +170,27 @@ func (s *server) routes() {
 	s.router.Handle("/group/join", c.Then(s.GroupJoin())).Methods("POST")
 	s.router.Handle("/group/inviteinfo", c.Then(s.GetGroupInviteInfo())).Methods("POST")
 	s.router.Handle("/group/updateparticipants", c.Then(s.UpdateGroupParticipants())).Methods("POST")
+	s.router.Handle("/group/requests", c.Then(s.GetGroupRequests())).Methods("GET")
+	s.router.Handle("/group/requests", c.Then(s.UpdateGroupRequests())).Methods("POST")
+	s.router.Handle("/group/approval", c.Then(s.SetGroupApproval())).Methods("POST")
+	s.router.Handle("/group/automation", c.Then(s.ListGroupAutomations())).Methods("GET")
+	s.router.Handle("/group/automation", c.Then(s.SetGroupAutomation())).Methods("POST")
+	s.router.Handle("/group/automation", c.Then(s.DeleteGroupAutomation())).Methods("DELETE")
+	s.router.Handle("/group/automation/enable", c.Then(s.ChangeGroupAutomationState(true))).Methods("POST")
+	s.router.Handle("/group/automation/disable", c.Then(s.ChangeGroupAutomationState(false))).Methods("POST")
+	s.router.Handle("/group/moderation", c.Then(s.ListGroupModeration())).Methods("GET")
+	s.router.Handle("/group/moderation", c.Then(s.SetGroupModeration())).Methods("POST")
+	s.router.Handle("/group/moderation", c.Then(s.DeleteGroupModeration())).Methods("DELETE")
+	s.router.Handle("/group/moderation/log", c.Then(s.GetGroupModerationLog())).Methods("GET")
+	s.router.Handle("/group/moderation/strikes", c.Then(s.GetGroupModerationStrikes())).Methods("GET")
+	s.router.Handle("/group/moderation/strikes", c.Then(s.ResetGroupModerationStrikes())).Methods("DELETE")
+
+	s.router.Handle("/community/create", c.Then(s.CreateCommunity())).Methods("POST")
+	s.router.Handle("/community/link", c.Then(s.LinkCommunityGroup())).Methods("POST")
+	s.router.Handle("/community/unlink", c.Then(s.UnlinkCommunityGroup())).Methods("POST")
+	s.router.Handle("/community/subgroups", c.Then(s.GetCommunitySubgroups())).Methods("GET")
+	s.router.Handle("/community/participants", c.Then(s.GetCommunityParticipants())).Methods("GET")
+	s.router.Handle("/community/announce", send.Then(s.SendCommunityAnnouncement())).Methods("POST")
 	s.router.Handle("/newsletter/list", c.Then(s.ListNewsletter())).Methods("GET")
 	// s.router.Handle("/newsletters/info", c.Then(s.GetNewsletterInfo())).Methods("GET")
 
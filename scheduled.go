@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// Scheduled messages are regular queue jobs with a send_at time. They stay in
+// the message_queue table until due, so they survive restarts and are picked
+// up again by the queue worker whenever the instance connects.
+
+const (
+	scheduledLate = "late" // send past due messages as soon as possible
+	scheduledDrop = "drop" // discard messages that could not be sent on time
+)
+
+// Layouts accepted for SendAt values without timezone, read in the instance timezone
+var sendAtLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// Parses the SendAt option of a send payload. Values with an offset (RFC3339)
+// are used as is, other values are taken as local time of the instance.
+func parseSendAt(db *sqlx.DB, userID int, value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	settings, _ := getInstanceSettings(db, userID)
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.Local
+	}
+	return parseSendAtIn(value, loc)
+}
+
+// Parses a SendAt value, reading the ones without timezone in loc
+func parseSendAtIn(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range sendAtLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid SendAt, use RFC3339 (2024-05-01T09:00:00-03:00) or local time (2024-05-01 09:00)")
+}
+
+// Returns the time a message is scheduled for, nil when it is not scheduled
+func scheduledAt(db *sqlx.DB, userid int, opts sendOptions) (*time.Time, error) {
+	if opts.SendAt == "" {
+		return nil, nil
+	}
+	sendAt, err := parseSendAt(db, userid, opts.SendAt)
+	if err != nil {
+		return nil, err
+	}
+	if sendAt.Before(time.Now()) {
+		return nil, errors.New("SendAt is in the past")
+	}
+	return &sendAt, nil
+}
+
+// Lists scheduled messages not sent yet
+func (s *server) ListScheduled() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		jobs := []sendJob{}
+		err := s.db.Select(&jobs, `SELECT job_id, message_id, recipient, status, attempts, last_error, sent_at, send_at, created_at
+			FROM message_queue WHERE user_id=$1 AND send_at IS NOT NULL AND status IN ($2, $3) ORDER BY send_at, id`,
+			userid, jobQueued, jobSending)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list scheduled messages: %v", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(jobs)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Cancels a scheduled message that was not sent yet
+func (s *server) CancelScheduled() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		vars := mux.Vars(r)
+		jobid := vars["id"]
+
+		result, err := s.db.Exec(`UPDATE message_queue SET status=$1, updated_at=NOW()
+			WHERE user_id=$2 AND job_id=$3 AND send_at IS NOT NULL AND status=$4`,
+			jobCancelled, userid, jobid, jobQueued)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not cancel scheduled message: %v", err)))
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Scheduled message not found or already sent"))
+			return
+		}
+
+		job, err := getSendJob(s.db, userid, jobid)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Scheduled message not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		responseJson, err := json.Marshal(job)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
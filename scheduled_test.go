@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSendAtIn(t *testing.T) {
+	loc := time.FixedZone("UTC-3", -3*60*60)
+
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2024-05-01T09:00:00-03:00", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), true},
+		{"2024-05-01T09:00:00Z", time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC), true},
+		{"2024-05-01T09:00:00", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), true},
+		{"2024-05-01T09:00", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), true},
+		{"2024-05-01 09:00:30", time.Date(2024, 5, 1, 12, 0, 30, 0, time.UTC), true},
+		{"2024-05-01 09:00", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), true},
+		{"2024-05-01", time.Time{}, false},
+		{"09:00", time.Time{}, false},
+		{"tomorrow", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, err := parseSendAtIn(test.value, loc)
+		if (err == nil) != test.ok {
+			t.Errorf("parseSendAtIn(%q) err = %v, want ok %v", test.value, err, test.ok)
+			continue
+		}
+		if test.ok && !got.Equal(test.want) {
+			t.Errorf("parseSendAtIn(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
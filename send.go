@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Envelope accepted by POST /chat/send. Type selects the builder for the
+// content, every other field works the same for all message types.
+type sendRequest struct {
+	messageContent
+	Phone      string        // recipient: phone number, user, group or newsletter JID
+	Group      string        // alias of Phone, kept for the poll payload
+	Id         string        // message id, generated when empty
+	Quoted     quotedMessage // message being replied to
+	Mentions   []string      // phone numbers or JIDs mentioned in the message, @number tokens of the text are added
+	MentionAll bool          // mention every participant of the group
+	Ephemeral  bool          // send as a disappearing message even if the timer of the chat is not known
+	ViewOnce   bool          // image, video and audio only
+
+	EphemeralExpiration uint32 // disappearing timer in seconds, the one of the chat when not given
+
+	LinkPreview bool         // text only, fetch the preview of the first URL
+	Preview     *linkPreview // text only, preview fields given by the caller
+	sendOptions
+
+	// Legacy quote and mention fields of the /chat/send/* payloads
+	ContextInfo waProto.ContextInfo
+}
+
+type quotedMessage struct {
+	Id          string // id of the quoted message
+	Participant string // sender of the quoted message, only needed when it is not in the message store
+}
+
+// Sends a message of any type
+func (s *server) Send() http.HandlerFunc {
+	return s.sendAs("")
+}
+
+// Returns the handler for a /chat/send/* route, which takes the same
+// envelope as /chat/send with the Type fixed by the route
+func (s *server) sendAs(msgType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t sendRequest
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+		if msgType != "" {
+			t.Type = msgType
+		}
+
+		s.sendContent(w, r, userid, &t)
+	}
+}
+
+// Builds the message of an envelope and sends or queues it
+func (s *server) sendContent(w http.ResponseWriter, r *http.Request, userid int, t *sendRequest) {
+	if t.Phone == "" {
+		t.Phone = t.Group
+	}
+	if t.Phone == "" {
+		s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Phone in Payload"))
+		return
+	}
+
+	recipient, ok := parseJID(t.Phone)
+	if !ok {
+		s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse Phone"))
+		return
+	}
+
+	msg, err := buildMessage(r.Context(), clientPointer[userid], t.messageContent)
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		s.Respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil {
+		s.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if t.LinkPreview || t.Preview != nil {
+		if err := s.applyLinkPreview(r.Context(), userid, msg, t); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	msg, err = s.applyEnvelope(r.Context(), userid, recipient, msg, t)
+	if err != nil {
+		s.Respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	msgid := t.Id
+	if msgid == "" {
+		msgid = whatsmeow.GenerateMessageID()
+	}
+
+	s.deliver(w, r, userid, recipient, msg, msgid, t.sendOptions)
+}
+
+// Applies the type independent options of the envelope to a built message
+func (s *server) applyEnvelope(ctx context.Context, userid int, recipient types.JID, msg *waProto.Message, t *sendRequest) (*waProto.Message, error) {
+
+	quoted := t.Quoted
+	if quoted.Id == "" && t.ContextInfo.StanzaID != nil {
+		quoted.Id = t.ContextInfo.GetStanzaID()
+		quoted.Participant = t.ContextInfo.GetParticipant()
+	}
+
+	mentions, err := s.resolveMentions(ctx, userid, recipient, msg, t)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.ViewOnce {
+		if msg, err = wrapViewOnce(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Messages follow the disappearing timer of the chat when it is known
+	expiration := t.EphemeralExpiration
+	if expiration > 0 {
+		if err := validEphemeralExpiration(expiration); err != nil {
+			return nil, err
+		}
+	} else if t.Ephemeral {
+		expiration = s.disappearingTimer(ctx, userid, recipient)
+	} else if timer, ok := getEphemeralTimer(s.db, userid, recipient); ok {
+		expiration = timer
+	}
+	explicit := quoted.Id != "" || len(mentions) > 0 || t.Ephemeral || t.EphemeralExpiration > 0
+
+	if !explicit && expiration == 0 {
+		return msg, nil
+	}
+
+	contextInfo := contextInfoOf(msg)
+	if contextInfo == nil {
+		if !explicit {
+			return msg, nil
+		}
+		return nil, fmt.Errorf("Quotes, mentions and ephemeral are not supported for %s messages", t.Type)
+	}
+	if quoted.Id != "" {
+		if err := s.applyQuote(userid, contextInfo, quoted); err != nil {
+			return nil, err
+		}
+	}
+	if len(mentions) > 0 {
+		contextInfo.MentionedJID = mentions
+	}
+	if expiration > 0 {
+		contextInfo.Expiration = proto.Uint32(expiration)
+	}
+	return msg, nil
+}
+
+// Fills the reply fields with the content of the quoted message, looked up
+// in the message store. The Participant is only needed for messages the
+// store does not know.
+func (s *server) applyQuote(userid int, contextInfo *waProto.ContextInfo, quoted quotedMessage) error {
+	contextInfo.StanzaID = proto.String(quoted.Id)
+
+	stored, err := loadStoredMessage(s.db, userid, quoted.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Warn().Err(err).Str("id", quoted.Id).Msg("Could not load quoted message")
+	}
+	if err == nil {
+		contextInfo.QuotedMessage = quotedContent(stored.Message)
+		if quoted.Participant == "" {
+			contextInfo.Participant = proto.String(stored.Sender.String())
+			return nil
+		}
+	} else {
+		if quoted.Participant == "" {
+			return errors.New("Quoted message not found, Participant is required")
+		}
+		contextInfo.QuotedMessage = &waProto.Message{Conversation: proto.String("")}
+	}
+
+	participant, ok := parseJID(quoted.Participant)
+	if !ok {
+		return errors.New("Could not parse Participant in Quoted")
+	}
+	contextInfo.Participant = proto.String(participant.String())
+	return nil
+}
+
+// Returns the ContextInfo of the content of a message, creating it if
+// needed. Content wrapped in a FutureProofMessage is looked up too. Returns
+// nil for messages that have no ContextInfo.
+func contextInfoOf(msg *waProto.Message) *waProto.ContextInfo {
+	var found *waProto.ContextInfo
+	msg.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return true
+		}
+		content := v.Message()
+		if wrapper, ok := content.Interface().(*waProto.FutureProofMessage); ok {
+			if wrapper.GetMessage() != nil {
+				found = contextInfoOf(wrapper.GetMessage())
+			}
+			return found == nil
+		}
+		field := content.Descriptor().Fields().ByName("contextInfo")
+		if field == nil {
+			return true
+		}
+		found, _ = content.Mutable(field).Message().Interface().(*waProto.ContextInfo)
+		return found == nil
+	})
+	return found
+}
+
+// Marks media as view once and wraps it the way the official clients do
+func wrapViewOnce(msg *waProto.Message) (*waProto.Message, error) {
+	switch {
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ViewOnce = proto.Bool(true)
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ViewOnce = proto.Bool(true)
+	case msg.AudioMessage != nil:
+		msg.AudioMessage.ViewOnce = proto.Bool(true)
+	default:
+		return nil, errors.New("ViewOnce is only supported for image, video and audio messages")
+	}
+	return &waProto.Message{ViewOnceMessage: &waProto.FutureProofMessage{Message: msg}}, nil
+}
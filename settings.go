@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Per-instance settings. Columns left NULL in the users table fall back to
+// the server wide defaults given on the command line.
+type instanceSettings struct {
+	QueueRate       int    // messages per minute sent by the outbound queue
+	QueueJitter     int    // maximum random delay in milliseconds added between queued messages
+	Timezone        string // used to read SendAt values without an explicit offset
+	ScheduledPolicy string // late or drop, for scheduled messages that could not be sent on time
+	SimulateTyping  bool   // show typing in the chat before sending messages
+}
+
+func getInstanceSettings(db *sqlx.DB, userID int) (instanceSettings, error) {
+	settings := instanceSettings{
+		QueueRate:       *queueRate,
+		QueueJitter:     *queueJitter,
+		Timezone:        time.Local.String(),
+		ScheduledPolicy: *scheduledPolicy,
+		SimulateTyping:  *simulateTyping,
+	}
+
+	var row struct {
+		QueueRate       sql.NullInt64  `db:"queue_rate"`
+		QueueJitter     sql.NullInt64  `db:"queue_jitter"`
+		Timezone        sql.NullString `db:"timezone"`
+		ScheduledPolicy sql.NullString `db:"scheduled_policy"`
+		SimulateTyping  sql.NullBool   `db:"simulate_typing"`
+	}
+	err := db.Get(&row, "SELECT queue_rate, queue_jitter, timezone, scheduled_policy, simulate_typing FROM users WHERE id=$1", userID)
+	if err != nil {
+		return settings, err
+	}
+
+	if row.QueueRate.Valid && row.QueueRate.Int64 > 0 {
+		settings.QueueRate = int(row.QueueRate.Int64)
+	}
+	if row.QueueJitter.Valid && row.QueueJitter.Int64 >= 0 {
+		settings.QueueJitter = int(row.QueueJitter.Int64)
+	}
+	if row.Timezone.Valid && row.Timezone.String != "" {
+		settings.Timezone = row.Timezone.String
+	}
+	if row.ScheduledPolicy.Valid && row.ScheduledPolicy.String != "" {
+		settings.ScheduledPolicy = row.ScheduledPolicy.String
+	}
+	if row.SimulateTyping.Valid {
+		settings.SimulateTyping = row.SimulateTyping.Bool
+	}
+	return settings, nil
+}
+
+// Gets the settings of the instance
+func (s *server) GetSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		settings, err := getInstanceSettings(s.db, userid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("Could not load settings"))
+			return
+		}
+
+		responseJson, err := json.Marshal(settings)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Changes the settings of the instance, fields not present in the payload are left untouched
+func (s *server) SetSettings() http.HandlerFunc {
+
+	type settingsStruct struct {
+		QueueRate       *int
+		QueueJitter     *int
+		Timezone        *string
+		ScheduledPolicy *string
+		SimulateTyping  *bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		decoder := json.NewDecoder(r.Body)
+		var t settingsStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.QueueRate != nil {
+			if *t.QueueRate < 1 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("QueueRate must be at least 1 message per minute"))
+				return
+			}
+			if _, err := s.db.Exec("UPDATE users SET queue_rate=$1 WHERE id=$2", *t.QueueRate, userid); err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("Could not save settings"))
+				return
+			}
+		}
+
+		if t.QueueJitter != nil {
+			if *t.QueueJitter < 0 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("QueueJitter cannot be negative"))
+				return
+			}
+			if _, err := s.db.Exec("UPDATE users SET queue_jitter=$1 WHERE id=$2", *t.QueueJitter, userid); err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("Could not save settings"))
+				return
+			}
+		}
+
+		if t.Timezone != nil {
+			// An empty timezone goes back to the server timezone
+			if _, err := time.LoadLocation(*t.Timezone); err != nil {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Invalid Timezone, use an IANA name like America/Sao_Paulo"))
+				return
+			}
+			if _, err := s.db.Exec("UPDATE users SET timezone=NULLIF($1, '') WHERE id=$2", *t.Timezone, userid); err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("Could not save settings"))
+				return
+			}
+		}
+
+		if t.ScheduledPolicy != nil {
+			if *t.ScheduledPolicy != scheduledLate && *t.ScheduledPolicy != scheduledDrop {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("ScheduledPolicy must be late or drop"))
+				return
+			}
+			if _, err := s.db.Exec("UPDATE users SET scheduled_policy=$1 WHERE id=$2", *t.ScheduledPolicy, userid); err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("Could not save settings"))
+				return
+			}
+		}
+
+		if t.SimulateTyping != nil {
+			if _, err := s.db.Exec("UPDATE users SET simulate_typing=$1 WHERE id=$2", *t.SimulateTyping, userid); err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New("Could not save settings"))
+				return
+			}
+		}
+
+		settings, err := getInstanceSettings(s.db, userid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("Could not load settings"))
+			return
+		}
+
+		responseJson, err := json.Marshal(settings)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
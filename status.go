@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Statuses are messages sent to status@broadcast. WhatsApp delivers them to
+// the audience set in the status privacy of the phone, there is no way to
+// pick the recipients of a single status. Statuses posted by contacts are
+// kept until they expire after 24 hours.
+
+const statusLifetime = 24 * time.Hour
+
+// Payload of the /status/send/* routes
+type statusRequest struct {
+	Body            string // text
+	BackgroundColor string // text, #RRGGBB or #AARRGGBB
+	TextColor       string // text, #RRGGBB or #AARRGGBB
+	Font            string // text, font name like SYSTEM_BOLD or its number
+
+	Caption       string // image and video
+	Image         string
+	Video         string
+	JPEGThumbnail []byte
+
+	Id       string   // message id, generated when empty
+	Audience []string // rejected, WhatsApp only sends to the audience of the status privacy
+	sendOptions
+}
+
+type statusRow struct {
+	ID        string     `db:"message_id" json:"Id"`
+	Sender    string     `db:"sender" json:"Sender"`
+	PushName  string     `db:"push_name" json:"PushName"`
+	Type      string     `db:"type" json:"Type"`
+	Data      []byte     `db:"message" json:"-"`
+	Timestamp time.Time  `db:"timestamp" json:"Timestamp"`
+	SeenAt    *time.Time `db:"seen_at" json:"SeenAt"`
+
+	Message *waProto.Message `db:"-" json:"Message"`
+}
+
+// Parses a #RRGGBB or #AARRGGBB colour to the ARGB value used by text statuses
+func parseARGB(color string) (uint32, error) {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) == 6 {
+		hex = "FF" + hex
+	}
+	if len(hex) != 8 {
+		return 0, fmt.Errorf("Invalid colour %q, use #RRGGBB or #AARRGGBB", color)
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid colour %q, use #RRGGBB or #AARRGGBB", color)
+	}
+	return uint32(value), nil
+}
+
+// Parses a font given by name or number
+func parseStatusFont(font string) (waProto.ExtendedTextMessage_FontType, error) {
+	values := waProto.ExtendedTextMessage_SYSTEM.Descriptor().Values()
+	if value := values.ByName(protoreflect.Name(strings.ToUpper(font))); value != nil {
+		return waProto.ExtendedTextMessage_FontType(value.Number()), nil
+	}
+	if number, err := strconv.Atoi(font); err == nil && values.ByNumber(protoreflect.EnumNumber(number)) != nil {
+		return waProto.ExtendedTextMessage_FontType(number), nil
+	}
+	return 0, fmt.Errorf("Unknown Font %q", font)
+}
+
+// Builds a text status with its colours and font
+func buildStatusText(t statusRequest) (*waProto.Message, error) {
+	if t.Body == "" {
+		return nil, errors.New("Missing Body in Payload")
+	}
+	text := &waProto.ExtendedTextMessage{Text: proto.String(t.Body)}
+
+	background, foreground := t.BackgroundColor, t.TextColor
+	if background == "" {
+		background = "#1E6E4F"
+	}
+	if foreground == "" {
+		foreground = "#FFFFFF"
+	}
+	argb, err := parseARGB(background)
+	if err != nil {
+		return nil, err
+	}
+	text.BackgroundArgb = proto.Uint32(argb)
+	if argb, err = parseARGB(foreground); err != nil {
+		return nil, err
+	}
+	text.TextArgb = proto.Uint32(argb)
+
+	if t.Font != "" {
+		font, err := parseStatusFont(t.Font)
+		if err != nil {
+			return nil, err
+		}
+		text.Font = font.Enum()
+	}
+	return &waProto.Message{ExtendedTextMessage: text}, nil
+}
+
+// Returns the handler of a /status/send/* route
+func (s *server) sendStatusAs(msgType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t statusRequest
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		// whatsmeow always picks the recipients of a status from the status
+		// privacy, so a list given here would be silently ignored
+		if len(t.Audience) > 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Audience is not supported, statuses are sent to the audience set in the status privacy of the phone, see /status/audience"))
+			return
+		}
+
+		var msg *waProto.Message
+		if msgType == "text" {
+			msg, err = buildStatusText(t)
+		} else {
+			msg, err = buildMessage(r.Context(), clientPointer[userid], messageContent{
+				Type:          msgType,
+				Caption:       t.Caption,
+				Image:         t.Image,
+				Video:         t.Video,
+				JPEGThumbnail: t.JPEGThumbnail,
+			})
+		}
+		var uploadErr *uploadError
+		if errors.As(err, &uploadErr) {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		msgid := t.Id
+		if msgid == "" {
+			msgid = whatsmeow.GenerateMessageID()
+		}
+
+		s.deliver(w, r, userid, types.StatusBroadcastJID, msg, msgid, t.sendOptions)
+	}
+}
+
+// Posts a text status
+func (s *server) SendStatusText() http.HandlerFunc {
+	return s.sendStatusAs("text")
+}
+
+// Posts an image status
+func (s *server) SendStatusImage() http.HandlerFunc {
+	return s.sendStatusAs("image")
+}
+
+// Posts a video status
+func (s *server) SendStatusVideo() http.HandlerFunc {
+	return s.sendStatusAs("video")
+}
+
+// Returns who receives the statuses posted by the instance
+func (s *server) GetStatusAudience() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		privacy, err := clientPointer[userid].GetStatusPrivacy(r.Context())
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not get status privacy: %v", err)))
+			return
+		}
+		if len(privacy) == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("No status privacy returned by WhatsApp"))
+			return
+		}
+
+		// The first setting is the one WhatsApp uses when sending
+		list := []string{}
+		for _, jid := range privacy[0].List {
+			list = append(list, jid.String())
+		}
+		response := map[string]interface{}{"Type": privacy[0].Type, "List": list}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Lists the statuses posted by contacts in the last 24 hours
+func (s *server) ListStatuses() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		query := `SELECT message_id, sender, push_name, type, message, timestamp, seen_at FROM statuses
+			WHERE user_id=$1 AND timestamp > $2`
+		args := []interface{}{userid, time.Now().Add(-statusLifetime)}
+		if unseen, _ := strconv.ParseBool(r.URL.Query().Get("unseen")); unseen {
+			query += " AND seen_at IS NULL"
+		}
+		if sender := r.URL.Query().Get("sender"); sender != "" {
+			jid, ok := parseJID(sender)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("Could not parse sender"))
+				return
+			}
+			args = append(args, jid.String())
+			query += " AND sender=$3"
+		}
+		query += " ORDER BY timestamp DESC"
+
+		rows := []statusRow{}
+		if err := s.db.Select(&rows, query, args...); err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list statuses: %v", err)))
+			return
+		}
+		for i := range rows {
+			rows[i].Message = &waProto.Message{}
+			if err := proto.Unmarshal(rows[i].Data, rows[i].Message); err != nil {
+				log.Warn().Err(err).Str("id", rows[i].ID).Msg("Could not decode status")
+			}
+		}
+
+		responseJson, err := json.Marshal(rows)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Sends read receipts for statuses of contacts
+func (s *server) MarkStatusesSeen() http.HandlerFunc {
+
+	type seenStruct struct {
+		Ids []string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t seenStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+		if len(t.Ids) == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Ids in Payload"))
+			return
+		}
+
+		var rows []statusRow
+		err = s.db.Select(&rows, "SELECT message_id, sender FROM statuses WHERE user_id=$1 AND message_id = ANY($2)", userid, pq.Array(t.Ids))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not load statuses: %v", err)))
+			return
+		}
+
+		// Receipts can only be sent for one sender at a time
+		bySender := map[string][]types.MessageID{}
+		found := map[string]bool{}
+		for _, row := range rows {
+			bySender[row.Sender] = append(bySender[row.Sender], row.ID)
+			found[row.ID] = true
+		}
+		notFound := []string{}
+		for _, id := range t.Ids {
+			if !found[id] {
+				notFound = append(notFound, id)
+			}
+		}
+		if len(rows) == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Statuses not found"))
+			return
+		}
+
+		now := time.Now()
+		seen := []string{}
+		for sender, ids := range bySender {
+			jid, err := types.ParseJID(sender)
+			if err != nil {
+				log.Warn().Err(err).Str("sender", sender).Msg("Could not parse status sender")
+				continue
+			}
+			if err := clientPointer[userid].MarkRead(r.Context(), ids, now, types.StatusBroadcastJID, jid); err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not mark statuses as seen: %v", err)))
+				return
+			}
+			if _, err := s.db.Exec("UPDATE statuses SET seen_at=$1 WHERE user_id=$2 AND message_id = ANY($3)", now, userid, pq.Array(ids)); err != nil {
+				log.Error().Err(err).Msg("Could not update seen statuses")
+			}
+			seen = append(seen, ids...)
+		}
+
+		response := map[string]interface{}{"Details": "Statuses marked as seen", "Seen": seen, "NotFound": notFound}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Returns the kind of content of a status
+func statusType(msg *waProto.Message) string {
+	switch {
+	case msg.Conversation != nil, msg.ExtendedTextMessage != nil:
+		return "text"
+	case msg.ImageMessage != nil:
+		return "image"
+	case msg.VideoMessage != nil:
+		return "video"
+	case msg.AudioMessage != nil:
+		return "audio"
+	}
+	return "other"
+}
+
+// Keeps a status posted by a contact. Statuses deleted by their sender are
+// removed.
+func storeStatus(db *sqlx.DB, userID int, evt *events.Message) {
+	if evt.Info.Chat != types.StatusBroadcastJID || evt.Info.IsFromMe || evt.Message == nil {
+		return
+	}
+
+	if protocol := evt.Message.GetProtocolMessage(); protocol != nil {
+		if protocol.GetType() == waProto.ProtocolMessage_REVOKE {
+			_, err := db.Exec("DELETE FROM statuses WHERE user_id=$1 AND message_id=$2", userID, protocol.GetKey().GetID())
+			if err != nil {
+				log.Error().Err(err).Msg("Could not delete revoked status")
+			}
+		}
+		return
+	}
+	if evt.Message.ReactionMessage != nil {
+		return
+	}
+
+	data, err := proto.Marshal(evt.Message)
+	if err != nil {
+		log.Error().Err(err).Str("id", evt.Info.ID).Msg("Could not encode status")
+		return
+	}
+	_, err = db.Exec(`INSERT INTO statuses (user_id, message_id, sender, push_name, type, message, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (user_id, message_id) DO NOTHING`,
+		userID, evt.Info.ID, evt.Info.Sender.ToNonAD().String(), evt.Info.PushName, statusType(evt.Message), data, evt.Info.Timestamp)
+	if err != nil {
+		log.Error().Err(err).Str("id", evt.Info.ID).Msg("Could not store status")
+	}
+}
+
+// Removes expired statuses from time to time
+func purgeStatuses(db *sqlx.DB) {
+	ticker := time.NewTicker(storePurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := db.Exec("DELETE FROM statuses WHERE timestamp < $1", time.Now().Add(-statusLifetime)); err != nil {
+			log.Error().Err(err).Msg("Could not purge statuses")
+		}
+	}
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Message templates are stored per instance in the message_templates table.
+// Every change creates a new version, sends use the latest one unless a
+// version is requested, so messages already sent can be traced back to the
+// exact text that was used.
+
+var templateNamePattern = regexp.MustCompile(`^[\w.-]{1,64}$`)
+
+type messageTemplate struct {
+	Name       string
+	Version    int
+	Body       string // text with {{variables}}, used as caption when there is media
+	Footer     string
+	Image      string // optional media as data URL, only one of them
+	Video      string
+	Document   string
+	FileName   string // document
+	Title      string // shown above the body of messages with buttons or sections
+	ButtonText string // opens the sections
+	Buttons    []messageButton
+	Sections   []messageSection
+	Media      string   // image, video or document, filled when loaded
+	Variables  []string // names used by the template, filled when loaded
+	CreatedAt  time.Time
+}
+
+func (tpl *messageTemplate) validate() error {
+	if !templateNamePattern.MatchString(tpl.Name) {
+		return errors.New("Name must have up to 64 letters, digits, dots, dashes or underscores")
+	}
+	if tpl.Body == "" {
+		return errors.New("Missing Body in Payload")
+	}
+
+	media := 0
+	for _, value := range []string{tpl.Image, tpl.Video, tpl.Document} {
+		if value == "" {
+			continue
+		}
+		if !strings.HasPrefix(value, "data:") {
+			return errors.New("Media data should start with \"data:mime/type;base64,\"")
+		}
+		media++
+	}
+	if media > 1 {
+		return errors.New("Only one of Image, Video or Document can be set")
+	}
+	if tpl.Document != "" && tpl.FileName == "" {
+		return errors.New("Missing FileName in Payload")
+	}
+
+	if len(tpl.Buttons) > interactiveMaxButtons {
+		return fmt.Errorf("Templates can have at most %d Buttons", interactiveMaxButtons)
+	}
+	for i, button := range tpl.Buttons {
+		// A URL with variables is only known once rendered, it is checked
+		// again when the message is built
+		if strings.Contains(button.URL, "{{") {
+			button.URL = "https://example.com"
+		}
+		if _, err := nativeFlowButton(i, button); err != nil {
+			return err
+		}
+	}
+	if len(tpl.Sections) > 0 {
+		if _, err := nativeFlowList(tpl.content()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fills the fields derived from the definition
+func (tpl *messageTemplate) describe() {
+	switch {
+	case tpl.Image != "":
+		tpl.Media = "image"
+	case tpl.Video != "":
+		tpl.Media = "video"
+	case tpl.Document != "":
+		tpl.Media = "document"
+	default:
+		tpl.Media = ""
+	}
+
+	texts := []string{tpl.Body, tpl.Footer, tpl.Title, tpl.ButtonText}
+	for _, button := range tpl.Buttons {
+		texts = append(texts, button.ButtonText, button.URL, button.CopyCode)
+	}
+	for _, section := range tpl.Sections {
+		texts = append(texts, section.Title)
+		for _, row := range section.Rows {
+			texts = append(texts, row.Title, row.Description)
+		}
+	}
+
+	tpl.Variables = []string{}
+	seen := map[string]bool{}
+	for _, text := range texts {
+		for _, match := range variablePattern.FindAllStringSubmatch(text, -1) {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				tpl.Variables = append(tpl.Variables, match[1])
+			}
+		}
+	}
+}
+
+// Returns the variables used by the template that have no value
+func (tpl *messageTemplate) missing(vars map[string]string) []string {
+	missing := []string{}
+	for _, name := range tpl.Variables {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// Returns a copy of the template with its variables replaced
+func (tpl messageTemplate) render(vars map[string]string) messageTemplate {
+	tpl.Body = renderVariables(tpl.Body, vars)
+	tpl.Footer = renderVariables(tpl.Footer, vars)
+	tpl.Title = renderVariables(tpl.Title, vars)
+	tpl.ButtonText = renderVariables(tpl.ButtonText, vars)
+
+	buttons := make([]messageButton, len(tpl.Buttons))
+	for i, button := range tpl.Buttons {
+		buttons[i] = button
+		buttons[i].ButtonText = renderVariables(button.ButtonText, vars)
+		buttons[i].URL = renderVariables(button.URL, vars)
+		buttons[i].CopyCode = renderVariables(button.CopyCode, vars)
+	}
+	tpl.Buttons = buttons
+
+	sections := make([]messageSection, len(tpl.Sections))
+	for i, section := range tpl.Sections {
+		rows := make([]messageRow, len(section.Rows))
+		for j, row := range section.Rows {
+			rows[j] = messageRow{RowId: row.RowId, Title: renderVariables(row.Title, vars), Description: renderVariables(row.Description, vars)}
+		}
+		sections[i] = messageSection{Title: renderVariables(section.Title, vars), Rows: rows}
+	}
+	tpl.Sections = sections
+	return tpl
+}
+
+// Returns the content to build the message of a rendered template
+func (tpl messageTemplate) content() messageContent {
+	c := messageContent{
+		Image:    tpl.Image,
+		Video:    tpl.Video,
+		Document: tpl.Document,
+		FileName: tpl.FileName,
+	}
+
+	// Buttons and sections are sent as native flow buttons, the legacy
+	// buttons and list messages are no longer shown by the clients
+	if len(tpl.Buttons) > 0 || len(tpl.Sections) > 0 {
+		c.Type = "interactive"
+		c.Body = tpl.Body
+		c.Title = tpl.Title
+		c.FooterText = tpl.Footer
+		c.ButtonText = tpl.ButtonText
+		c.Buttons = tpl.Buttons
+		c.Sections = tpl.Sections
+		return c
+	}
+
+	// Plain messages have no footer, it goes after the text
+	text := tpl.Body
+	if tpl.Footer != "" {
+		text += "\n\n" + tpl.Footer
+	}
+	switch {
+	case tpl.Image != "":
+		c.Type = "image"
+		c.Caption = text
+	case tpl.Video != "":
+		c.Type = "video"
+		c.Caption = text
+	case tpl.Document != "":
+		c.Type = "document"
+		c.Caption = text
+	default:
+		c.Type = "text"
+		c.Body = text
+	}
+	return c
+}
+
+// Returns the template without the media data, for listings
+func (tpl messageTemplate) summary() messageTemplate {
+	tpl.Image = ""
+	tpl.Video = ""
+	tpl.Document = ""
+	return tpl
+}
+
+type templateRow struct {
+	Name       string    `db:"name"`
+	Version    int       `db:"version"`
+	Definition string    `db:"definition"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+func (row templateRow) template() (messageTemplate, error) {
+	var tpl messageTemplate
+	if err := json.Unmarshal([]byte(row.Definition), &tpl); err != nil {
+		return tpl, err
+	}
+	tpl.Name = row.Name
+	tpl.Version = row.Version
+	tpl.CreatedAt = row.CreatedAt
+	tpl.describe()
+	return tpl, nil
+}
+
+// Loads a version of a template, the latest one when version is 0
+func loadTemplate(db *sqlx.DB, userID int, name string, version int) (messageTemplate, error) {
+	var row templateRow
+	err := db.Get(&row, `SELECT name, version, definition, created_at FROM message_templates
+		WHERE user_id=$1 AND name=$2 AND ($3 = 0 OR version=$3) ORDER BY version DESC LIMIT 1`,
+		userID, name, version)
+	if err != nil {
+		return messageTemplate{}, err
+	}
+	return row.template()
+}
+
+// Stores the template as a new version
+func saveTemplate(db *sqlx.DB, userID int, tpl messageTemplate) (messageTemplate, error) {
+	definition, err := json.Marshal(messageTemplate{
+		Body:       tpl.Body,
+		Footer:     tpl.Footer,
+		Image:      tpl.Image,
+		Video:      tpl.Video,
+		Document:   tpl.Document,
+		FileName:   tpl.FileName,
+		Title:      tpl.Title,
+		ButtonText: tpl.ButtonText,
+		Buttons:    tpl.Buttons,
+		Sections:   tpl.Sections,
+	})
+	if err != nil {
+		return tpl, err
+	}
+
+	var row templateRow
+	err = db.Get(&row, `INSERT INTO message_templates (user_id, name, version, definition)
+		SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3 FROM message_templates WHERE user_id=$1 AND name=$2
+		RETURNING name, version, definition, created_at`, userID, tpl.Name, string(definition))
+	if err != nil {
+		return tpl, err
+	}
+	return row.template()
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// Decodes the template in the request body
+func decodeTemplate(r *http.Request) (messageTemplate, error) {
+	var tpl messageTemplate
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&tpl); err != nil {
+		return tpl, errors.New("Could not decode Payload")
+	}
+	return tpl, nil
+}
+
+func (s *server) respondTemplate(w http.ResponseWriter, r *http.Request, status int, tpl messageTemplate) {
+	responseJson, err := json.Marshal(tpl)
+	if err != nil {
+		s.Respond(w, r, http.StatusInternalServerError, err)
+	} else {
+		s.Respond(w, r, status, string(responseJson))
+	}
+}
+
+// Lists the latest version of every template
+func (s *server) ListTemplates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		rows := []templateRow{}
+		err := s.db.Select(&rows, `SELECT DISTINCT ON (name) name, version, definition, created_at
+			FROM message_templates WHERE user_id=$1 ORDER BY name, version DESC`, userid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list templates: %v", err)))
+			return
+		}
+
+		templates := []messageTemplate{}
+		for _, row := range rows {
+			tpl, err := row.template()
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			templates = append(templates, tpl.summary())
+		}
+
+		responseJson, err := json.Marshal(templates)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Creates a template
+func (s *server) CreateTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		tpl, err := decodeTemplate(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := tpl.validate(); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		_, err = loadTemplate(s.db, userid, tpl.Name, 0)
+		if err == nil {
+			s.Respond(w, r, http.StatusConflict, errors.New("Template already exists, use PUT to create a new version"))
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		tpl, err = saveTemplate(s.db, userid, tpl)
+		if isUniqueViolation(err) {
+			s.Respond(w, r, http.StatusConflict, errors.New("Template already exists, use PUT to create a new version"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not save template: %v", err)))
+			return
+		}
+
+		s.respondTemplate(w, r, http.StatusOK, tpl)
+	}
+}
+
+// Gets a template, the latest version unless ?version= is given
+func (s *server) GetTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		version, _ := strconv.Atoi(r.URL.Query().Get("version"))
+		tpl, err := loadTemplate(s.db, userid, mux.Vars(r)["name"], version)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Template not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respondTemplate(w, r, http.StatusOK, tpl)
+	}
+}
+
+// Saves a new version of a template
+func (s *server) UpdateTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+		name := mux.Vars(r)["name"]
+
+		tpl, err := decodeTemplate(r)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		tpl.Name = name
+		if err := tpl.validate(); err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		_, err = loadTemplate(s.db, userid, name, 0)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Template not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		tpl, err = saveTemplate(s.db, userid, tpl)
+		if isUniqueViolation(err) {
+			s.Respond(w, r, http.StatusConflict, errors.New("Template was changed at the same time, please retry"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not save template: %v", err)))
+			return
+		}
+
+		s.respondTemplate(w, r, http.StatusOK, tpl)
+	}
+}
+
+// Deletes a template with all its versions
+func (s *server) DeleteTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+		name := mux.Vars(r)["name"]
+
+		result, err := s.db.Exec("DELETE FROM message_templates WHERE user_id=$1 AND name=$2", userid, name)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not delete template: %v", err)))
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Template not found"))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Template deleted", "Name": name}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Lists every version of a template, newest first
+func (s *server) ListTemplateVersions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		rows := []templateRow{}
+		err := s.db.Select(&rows, `SELECT name, version, definition, created_at FROM message_templates
+			WHERE user_id=$1 AND name=$2 ORDER BY version DESC`, userid, mux.Vars(r)["name"])
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not list template versions: %v", err)))
+			return
+		}
+		if len(rows) == 0 {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Template not found"))
+			return
+		}
+
+		templates := []messageTemplate{}
+		for _, row := range rows {
+			tpl, err := row.template()
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			templates = append(templates, tpl.summary())
+		}
+
+		responseJson, err := json.Marshal(templates)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Renders a template with the given variables without sending it
+func (s *server) PreviewTemplate() http.HandlerFunc {
+
+	type previewStruct struct {
+		Version int
+		Vars    map[string]string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		decoder := json.NewDecoder(r.Body)
+		var t previewStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		tpl, err := loadTemplate(s.db, userid, mux.Vars(r)["name"], t.Version)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Template not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		rendered := tpl.render(t.Vars)
+		missing := tpl.missing(t.Vars)
+		response := map[string]interface{}{
+			"Type":     rendered.content().Type,
+			"Template": rendered.summary(),
+			"Missing":  missing,
+		}
+		// Button URLs with variables can only be checked once rendered
+		if len(missing) == 0 {
+			for i, button := range rendered.Buttons {
+				if _, err := nativeFlowButton(i, button); err != nil {
+					response["Error"] = err.Error()
+					break
+				}
+			}
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Sends a message rendered from a template. Takes the same envelope as
+// /chat/send, with the template instead of the content.
+func (s *server) SendTemplate() http.HandlerFunc {
+
+	type templateStruct struct {
+		Name    string
+		Version int
+		Vars    map[string]string
+		sendRequest
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("No session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t templateStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Could not decode Payload"))
+			return
+		}
+
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Name in Payload"))
+			return
+		}
+
+		tpl, err := loadTemplate(s.db, userid, t.Name, t.Version)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Respond(w, r, http.StatusNotFound, errors.New("Template not found"))
+			return
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if missing := tpl.missing(t.Vars); len(missing) > 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("Missing template variables: %s", strings.Join(missing, ", "))))
+			return
+		}
+
+		t.messageContent = tpl.render(t.Vars).content()
+		s.sendContent(w, r, userid, &t.sendRequest)
+	}
+}
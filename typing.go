@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Typing simulation shows "typing…", or "recording audio…" for voice notes,
+// in the chat before a message is sent, for about as long as a person would
+// take to write or record it.
+
+const (
+	typingCharsPerSecond = 12
+	typingMinDelay       = 1 * time.Second
+	typingMaxDelay       = 12 * time.Second
+	typingJitter         = 0.3 // fraction of the delay added or removed at random
+)
+
+// Tells whether a message should be sent after simulating typing. The
+// payload option takes precedence over the instance setting.
+func shouldSimulateTyping(db *sqlx.DB, userID int, recipient types.JID, opts sendOptions) bool {
+	switch recipient.Server {
+	case types.BroadcastServer, types.NewsletterServer:
+		// Status updates, broadcast lists and channels have no chat to type in
+		return false
+	}
+	if opts.SimulateTyping != nil {
+		return *opts.SimulateTyping
+	}
+	settings, err := getInstanceSettings(db, userID)
+	if err != nil {
+		log.Warn().Err(err).Int("userid", userID).Msg("Could not load instance settings, using defaults")
+	}
+	return settings.SimulateTyping
+}
+
+// Returns the presence media to show for a message and for how long
+func typingFor(msg *waProto.Message) (types.ChatPresenceMedia, time.Duration) {
+	for msg.GetViewOnceMessage() != nil || msg.GetViewOnceMessageV2() != nil || msg.GetEphemeralMessage() != nil {
+		switch {
+		case msg.GetViewOnceMessage() != nil:
+			msg = msg.GetViewOnceMessage().GetMessage()
+		case msg.GetViewOnceMessageV2() != nil:
+			msg = msg.GetViewOnceMessageV2().GetMessage()
+		default:
+			msg = msg.GetEphemeralMessage().GetMessage()
+		}
+	}
+
+	media := types.ChatPresenceMediaText
+	kind, text, content, _ := describeContent(msg)
+	var delay time.Duration
+	if kind == "audio" && content.PTT {
+		media = types.ChatPresenceMediaAudio
+		delay = time.Duration(content.Seconds) * time.Second
+	} else {
+		delay = time.Duration(len([]rune(text))) * time.Second / typingCharsPerSecond
+	}
+
+	delay += time.Duration((rand.Float64()*2 - 1) * typingJitter * float64(delay))
+	if delay < typingMinDelay {
+		delay = typingMinDelay
+	}
+	if delay > typingMaxDelay {
+		delay = typingMaxDelay
+	}
+	return media, delay
+}
+
+// Shows typing in a chat for the time the message would take to write.
+// Returns early when the context is done.
+func showTyping(ctx context.Context, cli *whatsmeow.Client, chat types.JID, msg *waProto.Message) {
+	media, delay := typingFor(msg)
+	err := cli.SendChatPresence(ctx, chat, types.ChatPresenceComposing, media)
+	if err != nil {
+		log.Warn().Err(err).Str("chat", chat.String()).Msg("Could not send typing presence")
+		return
+	}
+	log.Debug().Str("chat", chat.String()).Str("media", string(media)).Dur("delay", delay).Msg("Simulating typing")
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
+
+// Clears the typing presence of a chat after the message was sent
+func stopTyping(cli *whatsmeow.Client, chat types.JID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := cli.SendChatPresence(ctx, chat, types.ChatPresencePaused, types.ChatPresenceMediaText)
+	if err != nil {
+		log.Warn().Err(err).Str("chat", chat.String()).Msg("Could not clear typing presence")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Contacts are sent and received as vCard 3.0. Phones carry the waid
+// parameter, which is what makes WhatsApp offer to message the contact.
+
+type contactCard struct {
+	Name   string   `json:"name"`
+	Phones []string `json:"phones,omitempty"`
+	Emails []string `json:"emails,omitempty"`
+	Org    string   `json:"org,omitempty"`
+}
+
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`, "\r", "")
+
+var vcardUnescaper = strings.NewReplacer(`\\`, `\`, `\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n")
+
+// Returns the digits of a phone number
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// Builds the vCard of a contact
+func (c contactCard) vcard() (string, error) {
+	if c.Name == "" {
+		return "", errors.New("Missing name of contact")
+	}
+	if len(c.Phones) == 0 && len(c.Emails) == 0 {
+		return "", fmt.Errorf("Contact %q needs at least one phone or email", c.Name)
+	}
+
+	var b strings.Builder
+	b.WriteString("BEGIN:VCARD\r\nVERSION:3.0\r\n")
+	b.WriteString("N:;" + vcardEscaper.Replace(c.Name) + ";;;\r\n")
+	b.WriteString("FN:" + vcardEscaper.Replace(c.Name) + "\r\n")
+	if c.Org != "" {
+		b.WriteString("ORG:" + vcardEscaper.Replace(c.Org) + "\r\n")
+	}
+	for _, phone := range c.Phones {
+		digits := phoneDigits(phone)
+		if len(digits) < 8 || len(digits) > 15 {
+			return "", fmt.Errorf("Invalid phone %q of contact %q, use 8 to 15 digits with country code", phone, c.Name)
+		}
+		b.WriteString("TEL;type=CELL;type=VOICE;waid=" + digits + ":+" + digits + "\r\n")
+	}
+	for _, email := range c.Emails {
+		if !strings.Contains(email, "@") {
+			return "", fmt.Errorf("Invalid email %q of contact %q", email, c.Name)
+		}
+		b.WriteString("EMAIL;type=INTERNET:" + vcardEscaper.Replace(email) + "\r\n")
+	}
+	b.WriteString("END:VCARD")
+	return b.String(), nil
+}
+
+// Builds a contact message, or a contacts array message for several contacts
+func buildContactsMessage(contacts []contactCard) (*waProto.Message, error) {
+	messages := make([]*waProto.ContactMessage, len(contacts))
+	for i, contact := range contacts {
+		vcard, err := contact.vcard()
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = &waProto.ContactMessage{
+			DisplayName: proto.String(contact.Name),
+			Vcard:       proto.String(vcard),
+		}
+	}
+	if len(messages) == 1 {
+		return &waProto.Message{ContactMessage: messages[0]}, nil
+	}
+	return &waProto.Message{ContactsArrayMessage: &waProto.ContactsArrayMessage{
+		DisplayName: proto.String(fmt.Sprintf("%d contacts", len(messages))),
+		Contacts:    messages,
+	}}, nil
+}
+
+// Reads the name, phones, emails and organization of a vCard. Phones are
+// returned as the digits of their waid when they have one.
+func parseVcard(vcard string) contactCard {
+	var c contactCard
+	var structuredName string
+
+	// Long lines are folded with a leading space or tab
+	unfolded := strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(vcard)
+	for _, line := range strings.Split(unfolded, "\n") {
+		line = strings.TrimRight(line, "\r")
+		property, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		params := strings.Split(property, ";")
+		name := strings.ToUpper(params[0])
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			// Grouped properties like item1.TEL
+			name = name[i+1:]
+		}
+
+		switch name {
+		case "FN":
+			c.Name = vcardUnescaper.Replace(value)
+		case "N":
+			parts := strings.Split(value, ";")
+			if len(parts) > 1 {
+				structuredName = strings.TrimSpace(vcardUnescaper.Replace(parts[1] + " " + parts[0]))
+			} else {
+				structuredName = vcardUnescaper.Replace(value)
+			}
+		case "ORG":
+			org, _, _ := strings.Cut(value, ";")
+			c.Org = vcardUnescaper.Replace(org)
+		case "EMAIL":
+			c.Emails = append(c.Emails, vcardUnescaper.Replace(value))
+		case "TEL":
+			phone := strings.TrimSpace(value)
+			for _, param := range params[1:] {
+				if waid, ok := strings.CutPrefix(strings.ToLower(param), "waid="); ok && waid != "" {
+					phone = waid
+				}
+			}
+			c.Phones = append(c.Phones, phone)
+		}
+	}
+	if c.Name == "" {
+		c.Name = structuredName
+	}
+	return c
+}
@@ -270,11 +270,16 @@ func (s *server) startClient(userID int, textjid string, token string, subscript
 		}
 	}
 
+	startQueueWorker(s.db, userID, token)
+	startCampaignRunner(s.db, userID, token)
+
 	// Keep connected client live until disconnected/killed
 	for {
 		select {
 		case <-killchannel[userID]:
 			log.Info().Str("userid", strconv.Itoa(userID)).Msg("Received kill signal")
+			stopQueueWorker(userID)
+			stopCampaignRunner(userID)
 			client.Disconnect()
 			delete(clientPointer, userID)
 			sqlStmt := `UPDATE users SET qrcode=$1, connected=0 WHERE id=$2`
@@ -346,6 +351,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	case *events.Connected:
 		postmap["type"] = "Connected"
 		dowebhook = 1
+		go prefetchGroups(mycli.userID)
 		if len(mycli.WAClient.Store.PushName) == 0 {
 			break
 		}
@@ -403,6 +409,20 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	case *events.Message:
 		postmap["type"] = "Message"
 		dowebhook = 1
+		storeReceivedMessage(mycli.db, mycli.userID, evt)
+		storeStatus(mycli.db, mycli.userID, evt)
+		learnEphemeralTimer(mycli.db, mycli.userID, evt)
+		vote := mycli.recordPollVote(evt)
+		if reply := interactiveReplyOf(evt); reply != nil {
+			sendEventWebhook(mycli.userID, mycli.token, "InteractiveReply", reply)
+		}
+		postmap["message"] = normalizeMessage(evt, vote)
+		if evt.Info.IsGroup && evt.Info.Chat.Server == types.GroupServer {
+			if group := mycli.groupContextOf(evt); group != nil {
+				postmap["group"] = group
+			}
+			go mycli.moderateGroupMessage(evt)
+		}
 		metaParts := []string{fmt.Sprintf("pushname: %s", evt.Info.PushName), fmt.Sprintf("timestamp: %s", evt.Info.Timestamp)}
 		if evt.Info.Type != "" {
 			metaParts = append(metaParts, fmt.Sprintf("type: %s", evt.Info.Type))
@@ -589,10 +609,11 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		}
 
 	case *events.Receipt:
+		trackCampaignReceipt(mycli.db, mycli.userID, evt)
 		postmap["type"] = "ReadReceipt"
 		dowebhook = 1
 		if evt.Type == events.ReceiptTypeRead || evt.Type == events.ReceiptTypeReadSelf {
-			log.Info().Strs("id", evt.MessageIDs).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%d", evt.Timestamp)).Msg("Message was read")
+			log.Info().Strs("id", evt.MessageIDs).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%v", evt.Timestamp)).Msg("Message was read")
 			if evt.Type == events.ReceiptTypeRead {
 				postmap["state"] = "Read"
 			} else {
@@ -600,7 +621,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 			}
 		} else if evt.Type == events.ReceiptTypeDelivered {
 			postmap["state"] = "Delivered"
-			log.Info().Str("id", evt.MessageIDs[0]).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%d", evt.Timestamp)).Msg("Message delivered")
+			log.Info().Str("id", evt.MessageIDs[0]).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%v", evt.Timestamp)).Msg("Message delivered")
 		} else {
 			// Discard webhooks for inactive or other delivery types
 			return
@@ -613,7 +634,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 			if evt.LastSeen.IsZero() {
 				log.Info().Str("from", evt.From.String()).Msg("User is now offline")
 			} else {
-				log.Info().Str("from", evt.From.String()).Str("lastSeen", fmt.Sprintf("%d", evt.LastSeen)).Msg("User is now offline")
+				log.Info().Str("from", evt.From.String()).Str("lastSeen", fmt.Sprintf("%v", evt.LastSeen)).Msg("User is now offline")
 			}
 		} else {
 			postmap["state"] = "online"
@@ -654,11 +675,42 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		postmap["type"] = "AppState"
 		dowebhook = 1
 		log.Info().Str("index", fmt.Sprintf("%+v", evt.Index)).Str("actionValue", fmt.Sprintf("%+v", evt.SyncActionValue)).Msg("App state event received")
+	case *events.Archive:
+		if !evt.FromFullSync {
+			postmap["type"] = "Archive"
+			dowebhook = 1
+		}
+		log.Info().Str("jid", evt.JID.String()).Bool("archived", evt.Action.GetArchived()).Msg("Chat archive changed")
+	case *events.Pin:
+		if !evt.FromFullSync {
+			postmap["type"] = "Pin"
+			dowebhook = 1
+		}
+		log.Info().Str("jid", evt.JID.String()).Bool("pinned", evt.Action.GetPinned()).Msg("Chat pin changed")
+	case *events.Mute:
+		if !evt.FromFullSync {
+			postmap["type"] = "Mute"
+			dowebhook = 1
+		}
+		log.Info().Str("jid", evt.JID.String()).Bool("muted", evt.Action.GetMuted()).Msg("Chat mute changed")
+	case *events.Star:
+		if !evt.FromFullSync {
+			postmap["type"] = "Star"
+			dowebhook = 1
+		}
+		log.Info().Str("jid", evt.ChatJID.String()).Str("id", evt.MessageID).Bool("starred", evt.Action.GetStarred()).Msg("Message star changed")
+	case *events.DeleteForMe:
+		if !evt.FromFullSync {
+			postmap["type"] = "DeleteForMe"
+			dowebhook = 1
+		}
+		log.Info().Str("jid", evt.ChatJID.String()).Str("id", evt.MessageID).Msg("Message deleted for me")
 	case *events.LoggedOut:
 		postmap["type"] = "LoggedOut"
 		dowebhook = 1
 		log.Info().Str("reason", evt.Reason.String()).Msg("Logged out")
 		log.Info().Str("userid", strconv.Itoa(mycli.userID)).Str("token", mycli.token).Msg("LOGOUT EVENT - Sending webhook")
+		forgetCachedGroups(mycli.userID)
 		killchannel[mycli.userID] <- true
 		sqlStmt := `UPDATE users SET connected=0 WHERE id=$1`
 		_, err := mycli.db.Exec(sqlStmt, mycli.userID)
@@ -709,10 +761,24 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	case *events.GroupInfo:
 		postmap["type"] = "GroupInfo"
 		dowebhook = 1
+		if evt.Ephemeral != nil {
+			learnGroupEphemeral(mycli.db, mycli.userID, evt.JID, *evt.Ephemeral)
+		}
+		updateCachedGroup(mycli.userID, []types.JID{mycli.WAClient.Store.GetJID(), mycli.WAClient.Store.GetLID()}, evt)
+		for _, request := range groupJoinRequestsOf(evt) {
+			log.Info().Str("group", request.GroupJID).Str("jid", request.JID).Msg("Group join request received")
+			sendEventWebhook(mycli.userID, mycli.token, "GroupJoinRequest", request)
+		}
+		mycli.sendGroupChanges(evt)
+		if len(evt.Join) > 0 || len(evt.Leave) > 0 {
+			go mycli.runGroupAutomations(evt)
+		}
 		log.Info().Str("jid", evt.JID.String()).Msg("Group info updated")
 	case *events.JoinedGroup:
 		postmap["type"] = "JoinedGroup"
 		dowebhook = 1
+		learnGroupEphemeral(mycli.db, mycli.userID, evt.JID, evt.GroupInfo.GroupEphemeral)
+		cacheGroupInfo(mycli.userID, &evt.GroupInfo)
 		log.Info().Str("jid", evt.JID.String()).Msg("Joined group")
 	case *events.Picture:
 		postmap["type"] = "Picture"